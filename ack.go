@@ -0,0 +1,101 @@
+package ergonats
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// ErrInProgress can be returned from HandleMessage when AutoAck is enabled to
+// reset the message's ack timer without settling it. The behavior then takes
+// responsibility for acknowledging the message itself.
+var ErrInProgress = errors.New("message in progress")
+
+// RetryError asks the pull consumer to redeliver a message after Delay
+type RetryError struct {
+	Delay time.Duration
+	Err   error
+}
+
+func (e *RetryError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("retry after %s", e.Delay)
+	}
+	return fmt.Sprintf("retry after %s: %s", e.Delay, e.Err)
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// TermError asks the pull consumer to terminate a message so that it is never
+// redelivered
+type TermError struct {
+	Err error
+}
+
+func (e *TermError) Error() string {
+	if e.Err == nil {
+		return "terminated"
+	}
+	return fmt.Sprintf("terminated: %s", e.Err)
+}
+
+func (e *TermError) Unwrap() error {
+	return e.Err
+}
+
+// settledError marks a failure whose message was already settled, so the
+// pull consumer must not settle it again
+type settledError struct {
+	err error
+}
+
+func (e *settledError) Error() string {
+	return e.err.Error()
+}
+
+func (e *settledError) Unwrap() error {
+	return e.err
+}
+
+func alreadySettled(err error) error {
+	return &settledError{err: err}
+}
+
+// RetryAfter wraps err so that the message is redelivered after delay
+func RetryAfter(delay time.Duration, err error) error {
+	return &RetryError{Delay: delay, Err: err}
+}
+
+// Terminate wraps err so that the message is never redelivered
+func Terminate(err error) error {
+	return &TermError{Err: err}
+}
+
+// settleMessage acknowledges msg according to the error returned by a handler.
+// A nil error acks, ErrInProgress extends the ack deadline, a TermError terms,
+// a RetryError naks with a delay and any other error naks immediately. Errors
+// for messages the handler already settled are left alone.
+func settleMessage(msg jetstream.Msg, err error) error {
+	var term *TermError
+	var retry *RetryError
+	var settled *settledError
+
+	switch {
+	case err == nil:
+		return msg.Ack()
+	case errors.As(err, &settled):
+		return nil
+	case errors.Is(err, ErrInProgress):
+		return msg.InProgress()
+	case errors.As(err, &term):
+		return msg.TermWithReason(term.Error())
+	case errors.As(err, &retry):
+		return msg.NakWithDelay(retry.Delay)
+	default:
+		return msg.Nak()
+	}
+}
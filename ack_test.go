@@ -0,0 +1,71 @@
+package ergonats
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+type fakeMsg struct {
+	subject  string
+	data     []byte
+	headers  nats.Header
+	metadata *jetstream.MsgMetadata
+	settled  string
+	delay    time.Duration
+}
+
+func (m *fakeMsg) Metadata() (*jetstream.MsgMetadata, error) {
+	if m.metadata == nil {
+		return nil, jetstream.ErrNotJSMessage
+	}
+	return m.metadata, nil
+}
+func (m *fakeMsg) Data() []byte                      { return m.data }
+func (m *fakeMsg) Headers() nats.Header              { return m.headers }
+func (m *fakeMsg) Subject() string                   { return m.subject }
+func (m *fakeMsg) Reply() string                     { return "" }
+func (m *fakeMsg) Ack() error                        { m.settled = "ack"; return nil }
+func (m *fakeMsg) DoubleAck(_ context.Context) error { m.settled = "ack"; return nil }
+func (m *fakeMsg) Nak() error                        { m.settled = "nak"; return nil }
+func (m *fakeMsg) InProgress() error                 { m.settled = "wpi"; return nil }
+func (m *fakeMsg) Term() error                       { m.settled = "term"; return nil }
+func (m *fakeMsg) TermWithReason(_ string) error     { m.settled = "term"; return nil }
+func (m *fakeMsg) NakWithDelay(delay time.Duration) error {
+	m.settled = "nak"
+	m.delay = delay
+	return nil
+}
+
+func TestSettleMessage(t *testing.T) {
+	boom := errors.New("boom")
+	cases := []struct {
+		err     error
+		settled string
+		delay   time.Duration
+	}{
+		{nil, "ack", 0},
+		{boom, "nak", 0},
+		{ErrInProgress, "wpi", 0},
+		{Terminate(boom), "term", 0},
+		{RetryAfter(5*time.Second, boom), "nak", 5 * time.Second},
+	}
+
+	for _, c := range cases {
+		msg := &fakeMsg{subject: "test"}
+		if err := settleMessage(msg, c.err); err != nil {
+			t.Fatalf("failed to settle message: %s", err)
+		}
+		if msg.settled != c.settled || msg.delay != c.delay {
+			t.Fatalf("wrong settlement for %v: %s (%s)", c.err, msg.settled, msg.delay)
+		}
+	}
+
+	if !errors.Is(RetryAfter(time.Second, boom), boom) {
+		t.Fatalf("retry error should unwrap to its cause")
+	}
+}
@@ -0,0 +1,43 @@
+package ergonats
+
+import "time"
+
+const (
+	defaultBackoffInitial = 500 * time.Millisecond
+	defaultBackoffMax     = 30 * time.Second
+)
+
+// Backoff describes an exponential retry schedule. The delay doubles after
+// every attempt, starting at Initial and capped at Max. A MaxRetries of zero
+// retries forever.
+type Backoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	MaxRetries int
+}
+
+// Delay returns how long to wait before the given (zero-based) retry attempt
+func (b Backoff) Delay(attempt int) time.Duration {
+	initial := b.Initial
+	if initial <= 0 {
+		initial = defaultBackoffInitial
+	}
+	max := b.Max
+	if max <= 0 {
+		max = defaultBackoffMax
+	}
+
+	delay := initial
+	for i := 0; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// Exhausted reports whether the given number of attempts used up the policy
+func (b Backoff) Exhausted(attempts int) bool {
+	return b.MaxRetries > 0 && attempts >= b.MaxRetries
+}
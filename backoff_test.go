@@ -0,0 +1,22 @@
+package ergonats
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	b := Backoff{Initial: time.Second, Max: 5 * time.Second, MaxRetries: 3}
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for attempt, want := range expected {
+		if got := b.Delay(attempt); got != want {
+			t.Fatalf("wrong delay for attempt %d: %s", attempt, got)
+		}
+	}
+	if b.Exhausted(2) || !b.Exhausted(3) {
+		t.Fatalf("backoff should be exhausted after 3 attempts")
+	}
+	if (Backoff{}).Exhausted(100) {
+		t.Fatalf("zero MaxRetries should retry forever")
+	}
+}
@@ -0,0 +1,51 @@
+package ergonats
+
+import (
+	"encoding/json"
+
+	cloudevents "github.com/cloudevents/sdk-go"
+	"github.com/nats-io/nats.go"
+)
+
+// Codec decodes message payloads into values of type T
+type Codec[T any] interface {
+	Decode(data []byte, header nats.Header) (T, error)
+}
+
+// CodecFunc adapts a function to the Codec interface
+type CodecFunc[T any] func(data []byte, header nats.Header) (T, error)
+
+func (f CodecFunc[T]) Decode(data []byte, header nats.Header) (T, error) {
+	return f(data, header)
+}
+
+// UnmarshalCodec builds a codec from an unmarshal function with the same
+// shape as json.Unmarshal, such as msgpack.Unmarshal. Protobuf messages can be
+// decoded with a CodecFunc that calls proto.Unmarshal.
+func UnmarshalCodec[T any](unmarshal func([]byte, interface{}) error) Codec[T] {
+	return CodecFunc[T](func(data []byte, _ nats.Header) (T, error) {
+		var value T
+		err := unmarshal(data, &value)
+		return value, err
+	})
+}
+
+// JSONCodec decodes JSON payloads
+func JSONCodec[T any]() Codec[T] {
+	return UnmarshalCodec[T](json.Unmarshal)
+}
+
+// CloudEventCodec decodes a structured-mode JSON cloud event and then decodes
+// the event's data into T. Use JSONCodec[cloudevents.Event] to receive the
+// whole event instead.
+func CloudEventCodec[T any]() Codec[T] {
+	return CodecFunc[T](func(data []byte, _ nats.Header) (T, error) {
+		var value T
+		var event cloudevents.Event
+		if err := json.Unmarshal(data, &event); err != nil {
+			return value, err
+		}
+		err := event.DataAs(&value)
+		return value, err
+	})
+}
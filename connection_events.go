@@ -0,0 +1,156 @@
+package ergonats
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/ergo-services/ergo/etf"
+	"github.com/ergo-services/ergo/gen"
+	"github.com/nats-io/nats.go"
+)
+
+// MessageNatsDisconnected is sent when a connection loses its server. Err is
+// nil for a disconnect without an error.
+type MessageNatsDisconnected struct {
+	Conn *nats.Conn
+	Err  error
+}
+
+// MessageNatsReconnected is sent once a connection reconnected to URL
+type MessageNatsReconnected struct {
+	Conn *nats.Conn
+	URL  string
+}
+
+// MessageNatsClosed is sent when a connection is closed for good
+type MessageNatsClosed struct {
+	Conn *nats.Conn
+}
+
+// MessageNatsLameDuck is sent when the connected server enters lame duck mode
+// and is about to shut down
+type MessageNatsLameDuck struct {
+	Conn *nats.Conn
+}
+
+// MessageNatsSlowConsumer is sent when a subscription drops messages because
+// its pending limits were exceeded
+type MessageNatsSlowConsumer struct {
+	Conn    *nats.Conn
+	Subject string
+}
+
+// MessageNatsError is sent for any other asynchronous connection error.
+// Subject is empty for errors that are not tied to a subscription.
+type MessageNatsError struct {
+	Conn    *nats.Conn
+	Subject string
+	Err     error
+}
+
+// ConnectionEvents delivers NATS connection events to subscribed processes as
+// regular messages, received in HandleInfo (or HandlePullConsumerInfo)
+type ConnectionEvents struct {
+	mu          sync.Mutex
+	subscribers map[etf.Pid]gen.Process
+}
+
+func NewConnectionEvents() *ConnectionEvents {
+	return &ConnectionEvents{
+		subscribers: make(map[etf.Pid]gen.Process),
+	}
+}
+
+// Options returns the connect options that deliver every event. Lame duck
+// notifications can only be configured this way, when connecting. Handlers
+// set by later options replace these.
+func (e *ConnectionEvents) Options() []nats.Option {
+	return []nats.Option{
+		nats.DisconnectErrHandler(e.disconnected(nil)),
+		nats.ReconnectHandler(e.reconnected(nil)),
+		nats.ClosedHandler(e.closed(nil)),
+		nats.ErrorHandler(e.failed(nil)),
+		nats.LameDuckModeHandler(e.lameDuck),
+	}
+}
+
+// Watch delivers the events of an existing connection, calling any handlers
+// it already had first. Lame duck notifications need Options instead.
+func (e *ConnectionEvents) Watch(nc *nats.Conn) {
+	nc.SetDisconnectErrHandler(e.disconnected(nc.DisconnectErrHandler()))
+	nc.SetReconnectHandler(e.reconnected(nc.ReconnectHandler()))
+	nc.SetClosedHandler(e.closed(nc.ClosedHandler()))
+	nc.SetErrorHandler(e.failed(nc.ErrorHandler()))
+}
+
+// Subscribe delivers future events to process until it terminates or is
+// unsubscribed
+func (e *ConnectionEvents) Subscribe(process gen.Process) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.subscribers[process.Self()] = process
+}
+
+func (e *ConnectionEvents) Unsubscribe(process gen.Process) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.subscribers, process.Self())
+}
+
+func (e *ConnectionEvents) publish(message etf.Term) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for pid, process := range e.subscribers {
+		if !process.IsAlive() || process.Send(pid, message) != nil {
+			delete(e.subscribers, pid)
+		}
+	}
+}
+
+func (e *ConnectionEvents) disconnected(next nats.ConnErrHandler) nats.ConnErrHandler {
+	return func(nc *nats.Conn, err error) {
+		if next != nil {
+			next(nc, err)
+		}
+		e.publish(MessageNatsDisconnected{Conn: nc, Err: err})
+	}
+}
+
+func (e *ConnectionEvents) reconnected(next nats.ConnHandler) nats.ConnHandler {
+	return func(nc *nats.Conn) {
+		if next != nil {
+			next(nc)
+		}
+		e.publish(MessageNatsReconnected{Conn: nc, URL: nc.ConnectedUrlRedacted()})
+	}
+}
+
+func (e *ConnectionEvents) closed(next nats.ConnHandler) nats.ConnHandler {
+	return func(nc *nats.Conn) {
+		if next != nil {
+			next(nc)
+		}
+		e.publish(MessageNatsClosed{Conn: nc})
+	}
+}
+
+func (e *ConnectionEvents) lameDuck(nc *nats.Conn) {
+	e.publish(MessageNatsLameDuck{Conn: nc})
+}
+
+func (e *ConnectionEvents) failed(next nats.ErrHandler) nats.ErrHandler {
+	return func(nc *nats.Conn, sub *nats.Subscription, err error) {
+		if next != nil {
+			next(nc, sub, err)
+		}
+		var subject string
+		if sub != nil {
+			subject = sub.Subject
+		}
+		if errors.Is(err, nats.ErrSlowConsumer) {
+			e.publish(MessageNatsSlowConsumer{Conn: nc, Subject: subject})
+			return
+		}
+		e.publish(MessageNatsError{Conn: nc, Subject: subject, Err: err})
+	}
+}
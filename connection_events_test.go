@@ -0,0 +1,75 @@
+package ergonats
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ergo-services/ergo/etf"
+	"github.com/ergo-services/ergo/gen"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+type eventConsumer struct {
+	testConsumer
+
+	mu     sync.Mutex
+	events []etf.Term
+}
+
+func (c *eventConsumer) HandlePullConsumerInfo(_ *PullConsumerProcess, message etf.Term) gen.ServerStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.events = append(c.events, message)
+	return gen.ServerStatusOK
+}
+
+func (c *eventConsumer) disconnected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, event := range c.events {
+		if _, ok := event.(MessageNatsDisconnected); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPullConsumerReceivesConnectionEvents(t *testing.T) {
+	shutdown, nc := startNatsServer(t)
+	defer nc.Close()
+
+	createTestStream(t, nc, "EVENTS", "events.>")
+	events := NewConnectionEvents()
+	events.Watch(nc)
+
+	n := startTestNode(t)
+	defer n.Stop()
+
+	consumer := &eventConsumer{}
+	consumer.opts = PullConsumerOptions{
+		Connection: nc,
+		StreamName: "EVENTS",
+		NatsConsumerConfig: jetstream.ConsumerConfig{
+			Durable: "events",
+		},
+		ConnectionEvents: events,
+	}
+	p, err := n.Spawn("events", gen.ProcessOptions{}, consumer)
+	if err != nil {
+		t.Fatalf("failed to spawn consumer: %s", err)
+	}
+
+	shutdown()
+	waitFor(t, consumer.disconnected)
+
+	_ = p.Exit("normal")
+	if err := p.WaitWithTimeout(5 * time.Second); err != nil {
+		t.Fatalf("consumer did not stop: %s", err)
+	}
+	waitFor(t, func() bool {
+		events.mu.Lock()
+		defer events.mu.Unlock()
+		return len(events.subscribers) == 0
+	})
+}
@@ -0,0 +1,639 @@
+package ergonats
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ergo-services/ergo/etf"
+	"github.com/ergo-services/ergo/gen"
+	"github.com/nats-io/nats.go"
+)
+
+var (
+	ErrUnknownConnection = errors.New("unknown NATS connection")
+	ErrConnectionLost    = errors.New("NATS connection lost")
+)
+
+// MessageConnectionLookup asks a ConnectionManager for the named connection.
+// The reply is a *nats.Conn. When Watcher is set it is sent a
+// MessageConnectionReplaced each time the connection is replaced, and a
+// MessageConnectionLost once it is gone.
+type MessageConnectionLookup struct {
+	Name    string
+	Watcher gen.Process
+}
+
+// MessageConnectionReplaced is sent to the watchers of a connection once the
+// manager dialed it again, because it was restarted, replaced or closed. The
+// previous connection is drained right after, so watchers should move to Conn.
+type MessageConnectionReplaced struct {
+	Name string
+	Conn *nats.Conn
+}
+
+// MessageConnectionLost is sent to the watchers of a connection that closed
+// without being dialed again, and to the watchers of every connection when the
+// manager stops. A restarted manager has forgotten its watchers, so they must
+// look the connection up, and watch it, again.
+type MessageConnectionLost struct {
+	Name string
+}
+
+// MessageConnectionRestart asks a ConnectionManager to close the named
+// connection and dial it again. The reply is the new *nats.Conn, sent once it
+// is connected; the manager keeps serving lookups while it dials.
+type MessageConnectionRestart struct {
+	Name string
+}
+
+// MessageConnectionReplace asks a ConnectionManager to dial Config and replace
+// the connection with the same name, if any, once connected. The reply is the
+// new *nats.Conn, sent once it is connected.
+type MessageConnectionReplace struct {
+	Config ConnectionConfig
+}
+
+// messageConnectionClosed is sent by a connection's closed handler
+type messageConnectionClosed struct {
+	name string
+	conn *nats.Conn
+}
+
+// messageConnectionRedial asks the manager to dial a closed connection again
+type messageConnectionRedial struct {
+	name    string
+	attempt int
+}
+
+// messageConnectionDialed carries the outcome of a dial made in the
+// background back to the manager. requester is nil for redials.
+type messageConnectionDialed struct {
+	config    ConnectionConfig
+	attempt   int
+	conn      *nats.Conn
+	err       error
+	requester *dialRequester
+}
+
+// dialRequester is the caller of a Restart or Replace request, answered once
+// the dial completes
+type dialRequester struct {
+	from   gen.ServerFrom
+	ref    etf.Ref
+	direct bool
+}
+
+// errDialing tells the callbacks that the request is answered once dialed
+var errDialing = errors.New("dialing")
+
+// ConnectionConfig describes a named NATS connection
+type ConnectionConfig struct {
+	Name string
+	URLs []string
+
+	// CredentialsFile and NkeyFile authenticate with a creds file or an nkey
+	// seed file
+	CredentialsFile string
+	NkeyFile        string
+
+	// TLS configures the connection's TLS. RootCAs, ClientCert and ClientKey
+	// name PEM files and can be used instead of, or along with, TLS.
+	TLS        *tls.Config
+	RootCAs    []string
+	ClientCert string
+	ClientKey  string
+
+	// MaxReconnects and ReconnectWait tune the client's own reconnection.
+	// Zero keeps the client default and a negative MaxReconnects reconnects
+	// forever.
+	MaxReconnects int
+	ReconnectWait time.Duration
+
+	// Options are applied last
+	Options []nats.Option
+}
+
+type ConnectionManagerBehavior interface {
+	gen.ServerBehavior
+
+	InitConnectionManager(process *ConnectionManagerProcess, args ...etf.Term) (*ConnectionManagerOptions, error)
+}
+
+// ConnectionManager owns named NATS connections so that other processes can
+// look them up instead of dialing their own
+type ConnectionManager struct {
+	gen.Server
+}
+
+type ConnectionManagerOptions struct {
+	Logger      *slog.Logger
+	Connections []ConnectionConfig
+
+	// Redial, when set, dials a connection that closed on its own again with
+	// exponential backoff, without blocking lookups of the other connections.
+	// When nil, or once the retries are exhausted, the connection is dropped
+	// and its watchers are sent a MessageConnectionLost. It can still be
+	// dialed again with MessageConnectionRestart.
+	Redial *Backoff
+
+	// Events, when set, receives the events of every managed connection
+	Events *ConnectionEvents
+}
+
+type ConnectionManagerProcess struct {
+	gen.ServerProcess
+
+	options     ConnectionManagerOptions
+	configs     map[string]ConnectionConfig
+	connections map[string]*nats.Conn
+	watchers    map[string]map[etf.Pid]gen.Process
+	forwarder   *forwarder
+}
+
+// forwarder hands the closed callbacks of the managed connections and the
+// outcome of redials to the manager. Those run on other goroutines and only
+// touch its channel; the forwarding goroutine is the only one sending to the
+// process and is stopped before the process terminates. Redialed connections
+// are kept in dialed until the manager adopts them, so that shutdown can close
+// those it never will.
+type forwarder struct {
+	messages chan etf.Term
+	quit     chan struct{}
+	done     chan struct{}
+
+	mu      sync.Mutex
+	stopped bool
+	dialed  map[*nats.Conn]struct{}
+}
+
+func newForwarder(process gen.Process) *forwarder {
+	f := &forwarder{
+		messages: make(chan etf.Term),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+		dialed:   make(map[*nats.Conn]struct{}),
+	}
+	go func() {
+		defer close(f.done)
+		for {
+			select {
+			case msg := <-f.messages:
+				if process.Context().Err() != nil {
+					return
+				}
+				_ = process.Send(process.Self(), msg)
+			case <-f.quit:
+				return
+			}
+		}
+	}()
+	return f
+}
+
+func (f *forwarder) forward(message etf.Term) {
+	select {
+	case f.messages <- message:
+	case <-f.quit:
+	}
+}
+
+func (f *forwarder) handler(name string) nats.ConnHandler {
+	return func(nc *nats.Conn) {
+		f.forward(messageConnectionClosed{name: name, conn: nc})
+	}
+}
+
+// hold keeps a redialed connection until the manager adopts it. It reports
+// false, closing the connection, once the forwarder is stopped.
+func (f *forwarder) hold(nc *nats.Conn) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.stopped {
+		nc.Close()
+		return false
+	}
+	f.dialed[nc] = struct{}{}
+	return true
+}
+
+func (f *forwarder) adopt(nc *nats.Conn) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.dialed, nc)
+}
+
+// stop returns once the forwarding goroutine exited, closing the redialed
+// connections that were never adopted
+func (f *forwarder) stop() {
+	close(f.quit)
+	<-f.done
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stopped = true
+	for nc := range f.dialed {
+		nc.Close()
+	}
+	f.dialed = nil
+}
+
+func (process *ConnectionManagerProcess) Options() *ConnectionManagerOptions {
+	return &process.options
+}
+
+// Connection returns the named connection. It fails with ErrConnectionLost
+// for a connection that closed and is not connected again yet.
+func (process *ConnectionManagerProcess) Connection(name string) (*nats.Conn, error) {
+	nc, ok := process.connections[name]
+	if ok {
+		return nc, nil
+	}
+	if _, ok := process.configs[name]; ok {
+		return nil, fmt.Errorf("%w: %s", ErrConnectionLost, name)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownConnection, name)
+}
+
+// LookupConnection asks manager for the named connection
+func LookupConnection(manager gen.Process, name string) (*nats.Conn, error) {
+	return lookupConnection(manager, MessageConnectionLookup{Name: name})
+}
+
+// WatchConnection asks manager for the named connection and has watcher sent a
+// MessageConnectionReplaced whenever the manager replaces it, and a
+// MessageConnectionLost once it is gone
+func WatchConnection(manager gen.Process, name string, watcher gen.Process) (*nats.Conn, error) {
+	return lookupConnection(manager, MessageConnectionLookup{Name: name, Watcher: watcher})
+}
+
+func lookupConnection(manager gen.Process, lookup MessageConnectionLookup) (*nats.Conn, error) {
+	if manager == nil {
+		return nil, fmt.Errorf("connection manager is not running")
+	}
+	reply, err := manager.Direct(lookup)
+	if err != nil {
+		return nil, err
+	}
+	return reply.(*nats.Conn), nil
+}
+
+// gen.Server callbacks
+
+func (m *ConnectionManager) Init(process *gen.ServerProcess, args ...etf.Term) error {
+	managerProcess := &ConnectionManagerProcess{
+		ServerProcess: *process,
+		configs:       make(map[string]ConnectionConfig),
+		connections:   make(map[string]*nats.Conn),
+		watchers:      make(map[string]map[etf.Pid]gen.Process),
+	}
+	managerProcess.State = nil
+
+	behavior, ok := process.Behavior().(ConnectionManagerBehavior)
+	if !ok {
+		return fmt.Errorf("connection manager: not a ConnectionManagerBehavior")
+	}
+	opts, err := behavior.InitConnectionManager(managerProcess, args...)
+	if err != nil {
+		return err
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	managerProcess.options = *opts
+	managerProcess.forwarder = newForwarder(process)
+	process.State = managerProcess
+
+	for _, config := range opts.Connections {
+		if _, ok := managerProcess.configs[config.Name]; ok {
+			managerProcess.shutdown()
+			return invalidOption("connection manager", "Connections", "connection %q is declared twice", config.Name)
+		}
+		if err := managerProcess.connect(config); err != nil {
+			managerProcess.shutdown()
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *ConnectionManager) HandleCall(
+	process *gen.ServerProcess,
+	from gen.ServerFrom,
+	message etf.Term) (etf.Term, gen.ServerStatus) {
+
+	reply, err := process.State.(*ConnectionManagerProcess).handleRequest(message, dialRequester{from: from})
+	if err == errDialing {
+		return nil, gen.ServerStatusIgnore
+	}
+	if err != nil {
+		return err, gen.ServerStatusOK
+	}
+	return reply, gen.ServerStatusOK
+}
+
+func (m *ConnectionManager) HandleDirect(
+	process *gen.ServerProcess,
+	ref etf.Ref,
+	message interface{}) (interface{}, gen.DirectStatus) {
+
+	reply, err := process.State.(*ConnectionManagerProcess).handleRequest(message, dialRequester{ref: ref, direct: true})
+	if err == errDialing {
+		return nil, gen.DirectStatusIgnore
+	}
+	return reply, err
+}
+
+func (m *ConnectionManager) HandleInfo(
+	process *gen.ServerProcess,
+	message etf.Term) gen.ServerStatus {
+
+	p := process.State.(*ConnectionManagerProcess)
+
+	switch msg := message.(type) {
+	case messageConnectionClosed:
+		if p.connections[msg.name] != msg.conn {
+			// closed by the manager itself
+			return gen.ServerStatusOK
+		}
+		p.options.Logger.Warn("NATS connection closed", slog.String("connection", msg.name))
+		delete(p.connections, msg.name)
+		p.redial(msg.name, 0)
+	case messageConnectionRedial:
+		p.redial(msg.name, msg.attempt)
+	case messageConnectionDialed:
+		if msg.requester != nil {
+			p.dialed(msg)
+			return gen.ServerStatusOK
+		}
+		p.redialed(msg)
+	}
+
+	return gen.ServerStatusOK
+}
+
+func (m *ConnectionManager) Terminate(process *gen.ServerProcess, _ string) {
+	p, ok := process.State.(*ConnectionManagerProcess)
+	if !ok {
+		return
+	}
+	p.shutdown()
+}
+
+// handleRequest answers message, or returns errDialing when requester is
+// answered once a dial made in the background completes
+func (process *ConnectionManagerProcess) handleRequest(message interface{}, requester dialRequester) (interface{}, error) {
+	switch r := message.(type) {
+	case MessageConnectionLookup:
+		nc, err := process.Connection(r.Name)
+		if err == nil && r.Watcher != nil {
+			process.watch(r.Name, r.Watcher)
+		}
+		return nc, err
+	case MessageConnectionRestart:
+		config, ok := process.configs[r.Name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownConnection, r.Name)
+		}
+		process.dialAsync(config, 0, &requester)
+		return nil, errDialing
+	case MessageConnectionReplace:
+		if err := validateConnection(r.Config); err != nil {
+			return nil, err
+		}
+		process.dialAsync(r.Config, 0, &requester)
+		return nil, errDialing
+	}
+	return nil, fmt.Errorf("unsupported request")
+}
+
+// connect dials config and swaps it in for the connection of the same name
+func (process *ConnectionManagerProcess) connect(config ConnectionConfig) error {
+	if err := validateConnection(config); err != nil {
+		return err
+	}
+
+	nc, err := process.dial(config)
+	if err != nil {
+		return err
+	}
+	process.install(config, nc)
+	return nil
+}
+
+func validateConnection(config ConnectionConfig) error {
+	if strings.TrimSpace(config.Name) == "" {
+		return invalidOption("connection manager", "ConnectionConfig.Name", "a connection name is required")
+	}
+	if len(config.URLs) == 0 {
+		return invalidOption("connection manager", "ConnectionConfig.URLs", "connection %q has no URLs", config.Name)
+	}
+	return nil
+}
+
+// dialAsync dials config without blocking the manager and hands the outcome
+// back to it as a messageConnectionDialed
+func (process *ConnectionManagerProcess) dialAsync(config ConnectionConfig, attempt int, requester *dialRequester) {
+	go func() {
+		nc, err := process.dial(config)
+		if err == nil && !process.forwarder.hold(nc) {
+			return
+		}
+		process.forwarder.forward(messageConnectionDialed{
+			config:    config,
+			attempt:   attempt,
+			conn:      nc,
+			err:       err,
+			requester: requester,
+		})
+	}()
+}
+
+// dialed swaps in the connection dialed for a Restart or Replace request and
+// answers it
+func (process *ConnectionManagerProcess) dialed(dialed messageConnectionDialed) {
+	if dialed.err != nil {
+		dialed.requester.reply(process, nil, dialed.err)
+		return
+	}
+	process.forwarder.adopt(dialed.conn)
+	process.install(dialed.config, dialed.conn)
+	dialed.requester.reply(process, dialed.conn, nil)
+}
+
+// reply answers the request like HandleCall and HandleDirect would have
+func (r *dialRequester) reply(process *ConnectionManagerProcess, reply etf.Term, err error) {
+	if r.direct {
+		_ = process.Reply(r.ref, reply, err)
+		return
+	}
+	if err != nil {
+		reply = err
+	}
+	_ = process.SendReply(r.from, reply)
+}
+
+func (process *ConnectionManagerProcess) dial(config ConnectionConfig) (*nats.Conn, error) {
+	opts, err := process.natsOptions(config)
+	if err != nil {
+		return nil, err
+	}
+	nc, err := nats.Connect(strings.Join(config.URLs, ","), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect %s: %w", config.Name, err)
+	}
+	return nc, nil
+}
+
+// install swaps nc in for the connection of the same name, if any. The
+// previous one is only drained once the watchers were told.
+func (process *ConnectionManagerProcess) install(config ConnectionConfig, nc *nats.Conn) {
+	previous := process.connections[config.Name]
+	process.configs[config.Name] = config
+	process.connections[config.Name] = nc
+	process.replaced(config.Name, nc)
+	if previous != nil {
+		_ = previous.Drain()
+	}
+	process.options.Logger.Info("NATS connection established",
+		slog.String("connection", config.Name),
+		slog.String("url", nc.ConnectedUrlRedacted()),
+	)
+}
+
+func (process *ConnectionManagerProcess) natsOptions(config ConnectionConfig) ([]nats.Option, error) {
+	name := config.Name
+	closed := process.forwarder.handler(name)
+
+	opts := []nats.Option{nats.Name(name)}
+	if events := process.options.Events; events != nil {
+		opts = append(opts, events.Options()...)
+		opts = append(opts, nats.ClosedHandler(events.closed(closed)))
+	} else {
+		opts = append(opts, nats.ClosedHandler(closed))
+	}
+
+	if config.CredentialsFile != "" {
+		opts = append(opts, nats.UserCredentials(config.CredentialsFile))
+	}
+	if config.NkeyFile != "" {
+		nkey, err := nats.NkeyOptionFromSeed(config.NkeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load nkey for %s: %w", name, err)
+		}
+		opts = append(opts, nkey)
+	}
+	if config.TLS != nil {
+		opts = append(opts, nats.Secure(config.TLS))
+	}
+	if len(config.RootCAs) > 0 {
+		opts = append(opts, nats.RootCAs(config.RootCAs...))
+	}
+	if config.ClientCert != "" || config.ClientKey != "" {
+		opts = append(opts, nats.ClientCert(config.ClientCert, config.ClientKey))
+	}
+	if config.MaxReconnects != 0 {
+		opts = append(opts, nats.MaxReconnects(config.MaxReconnects))
+	}
+	if config.ReconnectWait > 0 {
+		opts = append(opts, nats.ReconnectWait(config.ReconnectWait))
+	}
+
+	return append(opts, config.Options...), nil
+}
+
+// redial dials a connection that closed on its own in the background, or
+// drops it when that is not allowed
+func (process *ConnectionManagerProcess) redial(name string, attempt int) {
+	config, ok := process.configs[name]
+	if !ok || process.connections[name] != nil {
+		// removed, or connected again in the meantime
+		return
+	}
+	if process.options.Redial == nil {
+		process.lost(name)
+		return
+	}
+
+	process.dialAsync(config, attempt, nil)
+}
+
+// redialed adopts a redialed connection, or schedules the next attempt
+func (process *ConnectionManagerProcess) redialed(dialed messageConnectionDialed) {
+	name := dialed.config.Name
+	if dialed.err == nil {
+		process.forwarder.adopt(dialed.conn)
+		config, ok := process.configs[name]
+		if !ok || process.connections[name] != nil {
+			dialed.conn.Close()
+			return
+		}
+		process.install(config, dialed.conn)
+		return
+	}
+
+	retry := process.options.Redial
+	if retry.Exhausted(dialed.attempt + 1) {
+		process.options.Logger.Error("Failed to redial NATS connection",
+			slog.String("connection", name),
+			slog.Any("error", dialed.err),
+		)
+		process.lost(name)
+		return
+	}
+
+	delay := retry.Delay(dialed.attempt)
+	process.options.Logger.Warn("Failed to redial NATS connection, retrying",
+		slog.String("connection", name),
+		slog.Duration("delay", delay),
+		slog.Any("error", dialed.err),
+	)
+	process.SendAfter(process.Self(), messageConnectionRedial{name: name, attempt: dialed.attempt + 1}, delay)
+}
+
+func (process *ConnectionManagerProcess) watch(name string, watcher gen.Process) {
+	watchers, ok := process.watchers[name]
+	if !ok {
+		watchers = make(map[etf.Pid]gen.Process)
+		process.watchers[name] = watchers
+	}
+	watchers[watcher.Self()] = watcher
+}
+
+// replaced tells the watchers of the named connection about its replacement,
+// forgetting those that are gone
+func (process *ConnectionManagerProcess) replaced(name string, nc *nats.Conn) {
+	process.notify(name, MessageConnectionReplaced{Name: name, Conn: nc})
+}
+
+// lost tells the watchers of the named connection that it is gone. They
+// have to watch it again once it is connected again.
+func (process *ConnectionManagerProcess) lost(name string) {
+	process.notify(name, MessageConnectionLost{Name: name})
+	delete(process.watchers, name)
+}
+
+func (process *ConnectionManagerProcess) notify(name string, message etf.Term) {
+	for pid, watcher := range process.watchers[name] {
+		if !watcher.IsAlive() || process.Send(pid, message) != nil {
+			delete(process.watchers[name], pid)
+		}
+	}
+}
+
+// shutdown stops forwarding closed callbacks and redials, tells every watcher
+// its connection is lost, then closes every connection
+func (process *ConnectionManagerProcess) shutdown() {
+	process.forwarder.stop()
+	for name := range process.watchers {
+		process.lost(name)
+	}
+	for name, nc := range process.connections {
+		delete(process.connections, name)
+		nc.Close()
+	}
+}
@@ -0,0 +1,255 @@
+package ergonats
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ergo-services/ergo/etf"
+	"github.com/ergo-services/ergo/gen"
+	"github.com/nats-io/nats.go"
+)
+
+type testConnectionManager struct {
+	ConnectionManager
+
+	opts ConnectionManagerOptions
+}
+
+func (m *testConnectionManager) InitConnectionManager(_ *ConnectionManagerProcess, _ ...etf.Term) (*ConnectionManagerOptions, error) {
+	return &m.opts, nil
+}
+
+type connectionWatcher struct {
+	gen.Server
+
+	mu       sync.Mutex
+	replaced []MessageConnectionReplaced
+	lost     []string
+}
+
+func (w *connectionWatcher) HandleInfo(_ *gen.ServerProcess, message etf.Term) gen.ServerStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	switch msg := message.(type) {
+	case MessageConnectionReplaced:
+		w.replaced = append(w.replaced, msg)
+	case MessageConnectionLost:
+		w.lost = append(w.lost, msg.Name)
+	}
+	return gen.ServerStatusOK
+}
+
+func (w *connectionWatcher) lostConnections() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]string(nil), w.lost...)
+}
+
+func (w *connectionWatcher) last() (MessageConnectionReplaced, int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.replaced) == 0 {
+		return MessageConnectionReplaced{}, 0
+	}
+	return w.replaced[len(w.replaced)-1], len(w.replaced)
+}
+
+func TestConnectionManager(t *testing.T) {
+	shutdown, nc := startNatsServer(t)
+	defer shutdown()
+	url := nc.ConnectedUrl()
+
+	n := startTestNode(t)
+	defer n.Stop()
+
+	manager := &testConnectionManager{opts: ConnectionManagerOptions{
+		Connections: []ConnectionConfig{
+			{Name: "events", URLs: []string{url}},
+			{Name: "commands", URLs: []string{url}},
+		},
+		Redial: &Backoff{MaxRetries: 3},
+	}}
+	p, err := n.Spawn("nats", gen.ProcessOptions{}, manager)
+	if err != nil {
+		t.Fatalf("failed to spawn connection manager: %s", err)
+	}
+
+	watcher := &connectionWatcher{}
+	wp, err := n.Spawn("nats_watcher", gen.ProcessOptions{}, watcher)
+	if err != nil {
+		t.Fatalf("failed to spawn watcher: %s", err)
+	}
+
+	events, err := WatchConnection(p, "events", wp)
+	if err != nil || !events.IsConnected() || events.Opts.Name != "events" {
+		t.Fatalf("failed to look up connection: %v", err)
+	}
+	if _, err := LookupConnection(p, "missing"); !errors.Is(err, ErrUnknownConnection) {
+		t.Fatalf("expected an unknown connection, got %v", err)
+	}
+
+	// a connection closed behind the manager's back is dialed again
+	events.Close()
+	var redialed *nats.Conn
+	waitFor(t, func() bool {
+		redialed, err = LookupConnection(p, "events")
+		return err == nil && redialed != events && redialed.IsConnected()
+	})
+	waitFor(t, func() bool {
+		replaced, _ := watcher.last()
+		return replaced.Name == "events" && replaced.Conn == redialed
+	})
+
+	// watchers learn about restarts before the previous connection drains
+	commands, err := WatchConnection(p, "commands", wp)
+	if err != nil {
+		t.Fatalf("failed to watch connection: %v", err)
+	}
+	reply, err := p.Direct(MessageConnectionRestart{Name: "commands"})
+	if err != nil || !reply.(*nats.Conn).IsConnected() {
+		t.Fatalf("failed to restart connection: %v", err)
+	}
+	waitFor(t, func() bool {
+		replaced, count := watcher.last()
+		return count == 2 && replaced.Name == "commands" && replaced.Conn == reply.(*nats.Conn)
+	})
+	waitFor(t, commands.IsClosed)
+
+	if _, err := p.Direct(MessageConnectionReplace{Config: ConnectionConfig{Name: "audit", URLs: []string{url}}}); err != nil {
+		t.Fatalf("failed to add connection: %v", err)
+	}
+	audit, err := LookupConnection(p, "audit")
+	if err != nil || !audit.IsConnected() {
+		t.Fatalf("failed to look up added connection: %v", err)
+	}
+
+	// connections do not outlive the manager, and their watchers are told
+	_ = p.Exit("normal")
+	waitFor(t, func() bool { return redialed.IsClosed() && audit.IsClosed() })
+	waitFor(t, func() bool { return len(watcher.lostConnections()) == 2 })
+}
+
+func TestConnectionManagerDropsClosedConnections(t *testing.T) {
+	shutdown, nc := startNatsServer(t)
+	defer shutdown()
+	url := nc.ConnectedUrl()
+
+	n := startTestNode(t)
+	defer n.Stop()
+
+	manager := &testConnectionManager{opts: ConnectionManagerOptions{
+		Connections: []ConnectionConfig{
+			{Name: "events", URLs: []string{url}},
+			{Name: "commands", URLs: []string{url}},
+		},
+	}}
+	p, err := n.Spawn("nats", gen.ProcessOptions{}, manager)
+	if err != nil {
+		t.Fatalf("failed to spawn connection manager: %s", err)
+	}
+
+	watcher := &connectionWatcher{}
+	wp, err := n.Spawn("nats_watcher", gen.ProcessOptions{}, watcher)
+	if err != nil {
+		t.Fatalf("failed to spawn watcher: %s", err)
+	}
+	events, err := WatchConnection(p, "events", wp)
+	if err != nil {
+		t.Fatalf("failed to watch connection: %v", err)
+	}
+	commands, err := WatchConnection(p, "commands", wp)
+	if err != nil {
+		t.Fatalf("failed to watch connection: %v", err)
+	}
+
+	// without Redial only the closed connection is dropped
+	events.Close()
+	waitFor(t, func() bool {
+		lost := watcher.lostConnections()
+		return len(lost) == 1 && lost[0] == "events"
+	})
+	if !p.IsAlive() || !commands.IsConnected() {
+		t.Fatalf("closing one connection took the others down")
+	}
+	if _, err := LookupConnection(p, "events"); !errors.Is(err, ErrConnectionLost) {
+		t.Fatalf("expected a lost connection, got %v", err)
+	}
+	if found, err := LookupConnection(p, "commands"); err != nil || found != commands {
+		t.Fatalf("failed to look up remaining connection: %v", err)
+	}
+
+	reply, err := p.Direct(MessageConnectionRestart{Name: "events"})
+	if err != nil || !reply.(*nats.Conn).IsConnected() {
+		t.Fatalf("failed to restart lost connection: %v", err)
+	}
+
+	// watchers are told when the manager stops
+	_ = p.Exit("normal")
+	waitFor(t, func() bool {
+		lost := watcher.lostConnections()
+		return len(lost) == 2 && lost[1] == "commands"
+	})
+	waitFor(t, commands.IsClosed)
+}
+
+func TestConnectionManagerDialsWithoutBlockingLookups(t *testing.T) {
+	shutdown, nc := startNatsServer(t)
+	defer shutdown()
+
+	// a server that accepts connections but never greets them
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	defer silent.Close()
+	go func() {
+		for {
+			conn, err := silent.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	n := startTestNode(t)
+	defer n.Stop()
+
+	manager := &testConnectionManager{opts: ConnectionManagerOptions{
+		Connections: []ConnectionConfig{{Name: "events", URLs: []string{nc.ConnectedUrl()}}},
+	}}
+	p, err := n.Spawn("nats", gen.ProcessOptions{}, manager)
+	if err != nil {
+		t.Fatalf("failed to spawn connection manager: %s", err)
+	}
+
+	replaced := make(chan error, 1)
+	go func() {
+		_, err := p.Direct(MessageConnectionReplace{Config: ConnectionConfig{
+			Name:    "audit",
+			URLs:    []string{"nats://" + silent.Addr().String()},
+			Options: []nats.Option{nats.Timeout(time.Second)},
+		}})
+		replaced <- err
+	}()
+
+	// lookups are answered while the manager waits on the silent server
+	time.Sleep(100 * time.Millisecond)
+	started := time.Now()
+	if _, err := LookupConnection(p, "events"); err != nil {
+		t.Fatalf("failed to look up connection: %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 500*time.Millisecond {
+		t.Fatalf("lookup waited %s on a dial", elapsed)
+	}
+
+	if err := <-replaced; err == nil {
+		t.Fatalf("expected the dial to the silent server to fail")
+	}
+	if _, err := LookupConnection(p, "audit"); !errors.Is(err, ErrUnknownConnection) {
+		t.Fatalf("expected the failed connection to stay unknown, got %v", err)
+	}
+}
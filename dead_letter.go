@@ -0,0 +1,269 @@
+package ergonats
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	HeaderDeadLetterSubject    = "x-ergonats-dlq-subject"
+	HeaderDeadLetterStream     = "x-ergonats-dlq-stream"
+	HeaderDeadLetterSequence   = "x-ergonats-dlq-sequence"
+	HeaderDeadLetterConsumer   = "x-ergonats-dlq-consumer"
+	HeaderDeadLetterDeliveries = "x-ergonats-dlq-deliveries"
+	HeaderDeadLetterError      = "x-ergonats-dlq-error"
+
+	headerDeadLetterPrefix = "x-ergonats-dlq-"
+
+	deadLetterTimeout = 5 * time.Second
+	replayBatchSize   = 100
+)
+
+var ErrDeadLetterDisabled = errors.New("pull consumer has no dead-letter subject")
+
+// DeadLetterOptions configures where messages that exhaust MaxDeliver are
+// republished. When StreamName is set, the stream is created on attachment if
+// it does not exist yet so that dead letters are persisted.
+type DeadLetterOptions struct {
+	Subject    string
+	StreamName string
+}
+
+// maxDeliveriesAdvisory is published by JetStream when a message reaches the
+// consumer's MaxDeliver
+type maxDeliveriesAdvisory struct {
+	Stream     string `json:"stream"`
+	Consumer   string `json:"consumer"`
+	StreamSeq  uint64 `json:"stream_seq"`
+	Deliveries uint64 `json:"deliveries"`
+}
+
+// messageMaxDeliveries carries a max-deliveries advisory into the process
+type messageMaxDeliveries struct {
+	advisory maxDeliveriesAdvisory
+}
+
+func maxDeliveriesSubject(stream, consumer string) string {
+	return fmt.Sprintf("$JS.EVENT.ADVISORY.CONSUMER.MAX_DELIVERIES.%s.%s", stream, consumer)
+}
+
+// watchDeadLetters provisions the dead-letter stream and subscribes to the
+// consumer's max-deliveries advisories, forwarding them to the process
+func (process *PullConsumerProcess) watchDeadLetters(ctx context.Context, js jetstream.JetStream, cons jetstream.Consumer) error {
+	dlq := process.options.DeadLetter
+	if dlq == nil || process.options.Ordered {
+		return nil
+	}
+
+	if dlq.StreamName != "" {
+		_, err := js.Stream(ctx, dlq.StreamName)
+		if errors.Is(err, jetstream.ErrStreamNotFound) {
+			_, err = js.CreateStream(ctx, jetstream.StreamConfig{
+				Name:     dlq.StreamName,
+				Subjects: []string{dlq.Subject},
+			})
+		}
+		if err != nil {
+			return fmt.Errorf("failed to provision dead-letter stream %s: %w", dlq.StreamName, err)
+		}
+	}
+
+	info := cons.CachedInfo()
+	subject := maxDeliveriesSubject(info.Stream, info.Name)
+	// processes bound to the same consumer share the advisories so each
+	// message is dead-lettered once
+	sub, err := process.options.Connection.QueueSubscribe(subject, info.Name, func(m *nats.Msg) {
+		var advisory maxDeliveriesAdvisory
+		if err := json.Unmarshal(m.Data, &advisory); err != nil {
+			process.options.Logger.Warn("Failed to decode max deliveries advisory", slog.Any("error", err))
+			return
+		}
+		_ = process.sendSelf(messageMaxDeliveries{advisory: advisory})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %w", subject, err)
+	}
+	process.pull.setAdvisories(sub)
+
+	return nil
+}
+
+// handleMaxDeliveries fetches the message named by the advisory from its
+// stream and republishes it to the dead-letter subject
+func (process *PullConsumerProcess) handleMaxDeliveries(advisory maxDeliveriesAdvisory) {
+	ctx, cancel := context.WithTimeout(context.Background(), deadLetterTimeout)
+	defer cancel()
+
+	lastErr := process.pull.takeFailure(advisory.StreamSeq)
+	err := process.republishDeadLetter(ctx, advisory, lastErr)
+	if err != nil {
+		process.options.Logger.Error("Failed to dead-letter message",
+			slog.String("stream", advisory.Stream),
+			slog.Uint64("sequence", advisory.StreamSeq),
+			slog.Any("error", err),
+		)
+		return
+	}
+
+	process.options.Logger.Warn("Message exhausted its deliveries and was dead-lettered",
+		slog.String("stream", advisory.Stream),
+		slog.String("consumer", advisory.Consumer),
+		slog.Uint64("sequence", advisory.StreamSeq),
+		slog.Uint64("deliveries", advisory.Deliveries),
+	)
+}
+
+func (process *PullConsumerProcess) republishDeadLetter(ctx context.Context, advisory maxDeliveriesAdvisory, lastErr string) error {
+	js, err := GetJetStream(process)
+	if err != nil {
+		return err
+	}
+	stream, err := js.Stream(ctx, advisory.Stream)
+	if err != nil {
+		return err
+	}
+	raw, err := stream.GetMsg(ctx, advisory.StreamSeq)
+	if err != nil {
+		return err
+	}
+
+	out := newDeadLetter(process.options.DeadLetter.Subject, raw.Subject, raw.Header, raw.Data)
+	out.Header.Set(HeaderDeadLetterStream, advisory.Stream)
+	out.Header.Set(HeaderDeadLetterSequence, strconv.FormatUint(advisory.StreamSeq, 10))
+	out.Header.Set(HeaderDeadLetterConsumer, advisory.Consumer)
+	out.Header.Set(HeaderDeadLetterDeliveries, strconv.FormatUint(advisory.Deliveries, 10))
+	if lastErr != "" {
+		out.Header.Set(HeaderDeadLetterError, lastErr)
+	}
+
+	_, err = js.PublishMsg(ctx, out)
+	return err
+}
+
+// DeadLetter republishes msg to the dead-letter subject right away, without
+// waiting for it to exhaust MaxDeliver, and then terminates it
+func (process *PullConsumerProcess) DeadLetter(msg jetstream.Msg, reason error) error {
+	if process.options.DeadLetter == nil {
+		return ErrDeadLetterDisabled
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), deadLetterTimeout)
+	defer cancel()
+
+	js, err := GetJetStream(process)
+	if err != nil {
+		return err
+	}
+
+	out := newDeadLetter(process.options.DeadLetter.Subject, msg.Subject(), msg.Headers(), msg.Data())
+	if meta, err := msg.Metadata(); err == nil {
+		out.Header.Set(HeaderDeadLetterStream, meta.Stream)
+		out.Header.Set(HeaderDeadLetterSequence, strconv.FormatUint(meta.Sequence.Stream, 10))
+		out.Header.Set(HeaderDeadLetterConsumer, meta.Consumer)
+		out.Header.Set(HeaderDeadLetterDeliveries, strconv.FormatUint(meta.NumDelivered, 10))
+	}
+	if reason != nil {
+		out.Header.Set(HeaderDeadLetterError, reason.Error())
+	}
+
+	if _, err := js.PublishMsg(ctx, out); err != nil {
+		return err
+	}
+	if reason != nil {
+		return msg.TermWithReason(reason.Error())
+	}
+	return msg.Term()
+}
+
+func newDeadLetter(subject, original string, header nats.Header, data []byte) *nats.Msg {
+	out := nats.NewMsg(subject)
+	copyPublishHeaders(out.Header, header)
+	out.Header.Set(HeaderDeadLetterSubject, original)
+	out.Data = data
+	return out
+}
+
+// copyPublishHeaders copies header to out, except for the headers that make
+// JetStream deduplicate or reject a publish, which only applied to the
+// original one
+func copyPublishHeaders(out, header nats.Header) {
+	for k, v := range header {
+		if k == nats.MsgIdHdr || strings.HasPrefix(k, "Nats-Expected-") {
+			continue
+		}
+		out[k] = append([]string(nil), v...)
+	}
+}
+
+// ReplayDeadLetters republishes every message in a dead-letter stream to its
+// original subject and removes it from the dead-letter stream. It returns the
+// number of messages replayed.
+func ReplayDeadLetters(ctx context.Context, js jetstream.JetStream, streamName string) (int, error) {
+	stream, err := js.Stream(ctx, streamName)
+	if err != nil {
+		return 0, err
+	}
+	cons, err := stream.OrderedConsumer(ctx, jetstream.OrderedConsumerConfig{})
+	if err != nil {
+		return 0, err
+	}
+
+	replayed := 0
+	for {
+		batch, err := cons.FetchNoWait(replayBatchSize)
+		if err != nil {
+			return replayed, err
+		}
+
+		fetched := 0
+		for msg := range batch.Messages() {
+			fetched++
+			original := msg.Headers().Get(HeaderDeadLetterSubject)
+			if original == "" {
+				continue
+			}
+			meta, err := msg.Metadata()
+			if err != nil {
+				return replayed, err
+			}
+
+			out := nats.NewMsg(original)
+			copyPublishHeaders(out.Header, msg.Headers())
+			for k := range out.Header {
+				if strings.HasPrefix(k, headerDeadLetterPrefix) {
+					delete(out.Header, k)
+				}
+			}
+			out.Data = msg.Data()
+
+			ack, err := js.PublishMsg(ctx, out)
+			if err != nil {
+				return replayed, err
+			}
+			// a dropped duplicate must stay in the dead-letter stream
+			if ack.Duplicate {
+				return replayed, fmt.Errorf("replay of dead letter %d to %s was dropped as a duplicate",
+					meta.Sequence.Stream, original)
+			}
+			if err := stream.DeleteMsg(ctx, meta.Sequence.Stream); err != nil {
+				return replayed, err
+			}
+			replayed++
+		}
+		if err := batch.Error(); err != nil {
+			return replayed, err
+		}
+		if fetched == 0 {
+			return replayed, nil
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package ergonats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const dedupeTimeout = 5 * time.Second
+
+// DedupeOptions persists the stream sequence of the last processed message in
+// a KV bucket so that messages at or before it are skipped, even across
+// restarts. This only gives effectively-once processing when messages are
+// handled in stream order, so it requires an ordered consumer or a
+// MaxAckPending of 1.
+type DedupeOptions struct {
+	// Bucket is created when it does not exist
+	Bucket string
+	// Key defaults to the consumer name, or to the stream name for ordered
+	// consumers
+	Key string
+}
+
+// dedupeKey returns the KV key holding the last processed sequence
+func (opts PullConsumerOptions) dedupeKey() string {
+	if opts.Dedupe.Key != "" {
+		return opts.Dedupe.Key
+	}
+	if name := opts.consumerName(); name != "" && !opts.Ordered {
+		return name
+	}
+	return opts.StreamName
+}
+
+// attachDedupe opens the dedupe bucket and loads the last processed sequence.
+// Ordered consumers resume right after it.
+func (process *PullConsumerProcess) attachDedupe(ctx context.Context, js jetstream.JetStream) error {
+	dedupe := process.options.Dedupe
+	if dedupe == nil {
+		return nil
+	}
+
+	kv, err := js.KeyValue(ctx, dedupe.Bucket)
+	if errors.Is(err, jetstream.ErrBucketNotFound) {
+		kv, err = js.CreateKeyValue(ctx, jetstream.KeyValueConfig{Bucket: dedupe.Bucket})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open dedupe bucket %s: %w", dedupe.Bucket, err)
+	}
+
+	var persisted uint64
+	entry, err := kv.Get(ctx, process.options.dedupeKey())
+	switch {
+	case errors.Is(err, jetstream.ErrKeyNotFound):
+	case err != nil:
+		return fmt.Errorf("failed to load the last processed sequence: %w", err)
+	default:
+		persisted, err = strconv.ParseUint(string(entry.Value()), 10, 64)
+		if err != nil {
+			return fmt.Errorf("corrupt last processed sequence in %s: %w", dedupe.Bucket, err)
+		}
+	}
+
+	process.pull.setDedupe(kv, persisted)
+	if process.options.Ordered {
+		process.pull.advance(persisted)
+	}
+	return nil
+}
+
+// duplicate reports whether the message was already processed, acknowledging
+// it if so
+func (process *PullConsumerProcess) duplicate(msg jetstream.Msg, info *DeliveryInfo) bool {
+	if process.options.Dedupe == nil || info == nil || info.StreamSequence > process.pull.persisted.Load() {
+		return false
+	}
+	if !process.options.Ordered {
+		_ = msg.Ack()
+	}
+	return true
+}
+
+// persist records a successfully processed message as the last one
+func (process *PullConsumerProcess) persist(info *DeliveryInfo, err error) {
+	kv := process.pull.dedupeBucket()
+	if kv == nil || info == nil || err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dedupeTimeout)
+	defer cancel()
+	seq := strconv.FormatUint(info.StreamSequence, 10)
+	if _, err := kv.Put(ctx, process.options.dedupeKey(), []byte(seq)); err != nil {
+		process.options.Logger.Warn("Failed to persist the last processed sequence",
+			slog.Uint64("sequence", info.StreamSequence),
+			slog.Any("error", err),
+		)
+		return
+	}
+	process.pull.persisted.Store(info.StreamSequence)
+}
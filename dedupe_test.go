@@ -0,0 +1,102 @@
+package ergonats
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/ergo-services/ergo/gen"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+type deliveryConsumer struct {
+	testConsumer
+
+	mu        sync.Mutex
+	sequences []uint64
+}
+
+func (c *deliveryConsumer) HandleMessage(process *PullConsumerProcess, _ jetstream.Msg) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sequences = append(c.sequences, process.Delivery().StreamSequence)
+	c.handled.Add(1)
+	return nil
+}
+
+func TestPullConsumerSkipsDuplicates(t *testing.T) {
+	shutdown, nc := startNatsServer(t)
+	defer shutdown()
+
+	js := createTestStream(t, nc, "DEDUPE", "dedupe.>")
+	for i := 1; i <= 4; i++ {
+		_, _ = js.Publish(context.Background(), fmt.Sprintf("dedupe.%d", i), nil)
+	}
+
+	// a previous run processed the first two messages but never acked them
+	kv, err := js.CreateKeyValue(context.Background(), jetstream.KeyValueConfig{Bucket: "processed"})
+	if err != nil {
+		t.Fatalf("failed to create bucket: %s", err)
+	}
+	_, _ = kv.Put(context.Background(), "dedupe", []byte("2"))
+
+	n := startTestNode(t)
+	defer n.Stop()
+
+	consumer := &deliveryConsumer{}
+	consumer.opts = PullConsumerOptions{
+		Connection: nc,
+		StreamName: "DEDUPE",
+		NatsConsumerConfig: jetstream.ConsumerConfig{
+			Durable:       "dedupe",
+			MaxAckPending: 1,
+		},
+		AutoAck: true,
+		Dedupe:  &DedupeOptions{Bucket: "processed"},
+	}
+	if _, err := n.Spawn("dedupe", gen.ProcessOptions{}, consumer); err != nil {
+		t.Fatalf("failed to spawn consumer: %s", err)
+	}
+
+	waitFor(t, func() bool {
+		cons, err := js.Consumer(context.Background(), "DEDUPE", "dedupe")
+		if err != nil {
+			return false
+		}
+		info, err := cons.Info(context.Background())
+		return err == nil && info.NumAckPending == 0 && info.NumPending == 0
+	})
+
+	consumer.mu.Lock()
+	if len(consumer.sequences) != 2 || consumer.sequences[0] != 3 || consumer.sequences[1] != 4 {
+		t.Fatalf("wrong messages handled: %v", consumer.sequences)
+	}
+	consumer.mu.Unlock()
+
+	entry, err := kv.Get(context.Background(), "dedupe")
+	if err != nil || string(entry.Value()) != "4" {
+		t.Fatalf("last processed sequence was not persisted: %v", err)
+	}
+}
+
+func TestDeliveryInfoFromContext(t *testing.T) {
+	msg := &fakeMsg{metadata: &jetstream.MsgMetadata{
+		Sequence:     jetstream.SequencePair{Stream: 7, Consumer: 3},
+		NumDelivered: 2,
+		Stream:       "ORDERS",
+	}}
+	info, err := NewDeliveryInfo(msg)
+	if err != nil {
+		t.Fatalf("failed to parse delivery info: %s", err)
+	}
+
+	got, ok := DeliveryInfoFrom(withDeliveryInfo(context.Background(), info))
+	if !ok || got.StreamSequence != 7 || got.ConsumerSequence != 3 || got.NumDelivered != 2 || got.Stream != "ORDERS" {
+		t.Fatalf("wrong delivery info: %+v", got)
+	}
+
+	if _, err := NewDeliveryInfo(&fakeMsg{}); err == nil {
+		t.Fatalf("messages without metadata have no delivery info")
+	}
+}
@@ -0,0 +1,56 @@
+package ergonats
+
+import (
+	"context"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// DeliveryInfo is the parsed JetStream metadata of a delivered message
+type DeliveryInfo struct {
+	Stream           string
+	Consumer         string
+	Domain           string
+	StreamSequence   uint64
+	ConsumerSequence uint64
+	// NumDelivered counts this delivery, so it is 1 on the first attempt
+	NumDelivered uint64
+	NumPending   uint64
+	Timestamp    time.Time
+}
+
+// NewDeliveryInfo parses msg's metadata. It fails for messages that were not
+// delivered by a JetStream consumer.
+func NewDeliveryInfo(msg jetstream.Msg) (*DeliveryInfo, error) {
+	meta, err := msg.Metadata()
+	if err != nil {
+		return nil, err
+	}
+	return &DeliveryInfo{
+		Stream:           meta.Stream,
+		Consumer:         meta.Consumer,
+		Domain:           meta.Domain,
+		StreamSequence:   meta.Sequence.Stream,
+		ConsumerSequence: meta.Sequence.Consumer,
+		NumDelivered:     meta.NumDelivered,
+		NumPending:       meta.NumPending,
+		Timestamp:        meta.Timestamp,
+	}, nil
+}
+
+type deliveryInfoKey struct{}
+
+// DeliveryInfoFrom returns the delivery info of the message being handled,
+// as passed to HandleMessageContext, middleware and routes
+func DeliveryInfoFrom(ctx context.Context) (*DeliveryInfo, bool) {
+	info, ok := ctx.Value(deliveryInfoKey{}).(*DeliveryInfo)
+	return info, ok
+}
+
+func withDeliveryInfo(ctx context.Context, info *DeliveryInfo) context.Context {
+	if info == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, deliveryInfoKey{}, info)
+}
@@ -23,6 +23,13 @@ type AggregateBehavior interface {
 	HandleCommand(process *AggregateProcess, state AggregateState, cmd Command) ([]cloudevents.Event, error)
 }
 
+// AggregateInfoHandler is an optional extension of AggregateBehavior for
+// receiving regular messages, such as the connection events delivered when
+// AggregateOptions.ConnectionEvents is set
+type AggregateInfoHandler interface {
+	HandleAggregateInfo(process *AggregateProcess, message etf.Term) gen.ServerStatus
+}
+
 type Aggregate struct {
 	ergonats.PullConsumer
 }
@@ -48,6 +55,9 @@ type AggregateOptions struct {
 	StateStoreMaxBytes     int
 	AggregateName          string
 	Middleware             []AggregateMiddleware
+	// ConnectionEvents, when set, delivers the events of the NATS connection
+	// to HandleAggregateInfo
+	ConnectionEvents *ergonats.ConnectionEvents
 }
 
 type AggregateMiddleware interface {
@@ -114,10 +124,11 @@ func (a *Aggregate) InitPullConsumer(
 
 	aggregateOpts.Logger.Info("Aggregate initialized", slog.String("name", aggregateOpts.AggregateName))
 	return &ergonats.PullConsumerOptions{
-		Logger:     aggregateOpts.Logger,
-		Connection: aggregateOpts.Connection,
-		JsDomain:   aggregateOpts.JsDomain,
-		StreamName: aggregateOpts.StreamName,
+		Logger:           aggregateOpts.Logger,
+		Connection:       aggregateOpts.Connection,
+		JsDomain:         aggregateOpts.JsDomain,
+		StreamName:       aggregateOpts.StreamName,
+		ConnectionEvents: aggregateOpts.ConnectionEvents,
 		NatsConsumerConfig: jetstream.ConsumerConfig{
 			Durable:     consumerName,
 			Name:        consumerName,
@@ -278,6 +289,16 @@ func (a *Aggregate) HandleMessage(process *ergonats.PullConsumerProcess, msg jet
 	return nil
 }
 
+// HandlePullConsumerInfo forwards regular messages to the behavior's
+// HandleAggregateInfo, if it has one
+func (a *Aggregate) HandlePullConsumerInfo(process *ergonats.PullConsumerProcess, message etf.Term) gen.ServerStatus {
+	p := process.State.(*AggregateProcess)
+	if handler, ok := p.behavior.(AggregateInfoHandler); ok {
+		return handler.HandleAggregateInfo(p, message)
+	}
+	return gen.ServerStatusOK
+}
+
 func (a *Aggregate) writeEvents(process *AggregateProcess, events []cloudevents.Event) error {
 	return writeEvents(process.options.Connection,
 		process.options.StreamName,
@@ -21,11 +21,11 @@ func (c *MyConsumer) InitPullConsumer(
 		Connection:         args[0].(*nats.Conn),
 		StreamName:         "EVENTS",
 		NatsConsumerConfig: jetstream.ConsumerConfig{},
+		AutoAck:            true,
 	}, nil
 }
 
 func (c *MyConsumer) HandleMessage(_ *ergonats.PullConsumerProcess, msg jetstream.Msg) error {
-	_ = msg.Ack()
 	fmt.Println("Received message on", msg.Subject())
 	return nil
 }
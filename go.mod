@@ -3,16 +3,19 @@ module github.com/autodidaddict/ergonats
 go 1.21.6
 
 require (
-	github.com/cloudevents/sdk-go v1.2.0 // indirect
-	github.com/ergo-services/ergo v1.999.224 // indirect
-	github.com/google/uuid v1.6.0 // indirect
+	github.com/cloudevents/sdk-go v1.2.0
+	github.com/ergo-services/ergo v1.999.224
+	github.com/google/uuid v1.6.0
+	github.com/nats-io/nats-server/v2 v2.10.16
+	github.com/nats-io/nats.go v1.35.0
+)
+
+require (
 	github.com/hashicorp/golang-lru v0.5.1 // indirect
 	github.com/klauspost/compress v1.17.8 // indirect
 	github.com/lightstep/tracecontext.go v0.0.0-20181129014701-1757c391b1ac // indirect
 	github.com/minio/highwayhash v1.0.2 // indirect
 	github.com/nats-io/jwt/v2 v2.5.7 // indirect
-	github.com/nats-io/nats-server/v2 v2.10.16 // indirect
-	github.com/nats-io/nats.go v1.35.0 // indirect
 	github.com/nats-io/nkeys v0.4.7 // indirect
 	github.com/nats-io/nuid v1.0.1 // indirect
 	github.com/pkg/errors v0.8.1 // indirect
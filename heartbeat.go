@@ -0,0 +1,117 @@
+package ergonats
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	defaultHeartbeatFraction = 0.5
+	defaultAckWait           = 30 * time.Second
+)
+
+// ErrHeartbeatCeiling is reported for a message whose handler was still running
+// when HeartbeatOptions.Ceiling elapsed. The message is treated as failed even
+// if the handler eventually succeeds, since JetStream may already be
+// redelivering it.
+var ErrHeartbeatCeiling = errors.New("handler exceeded the in-progress ceiling")
+
+// HeartbeatOptions keeps long-running handlers from having their messages
+// redelivered by sending InProgress while they run
+type HeartbeatOptions struct {
+	// Fraction of the consumer's AckWait between two InProgress calls.
+	// Defaults to 0.5.
+	Fraction float64
+
+	// Ceiling bounds how long a message is kept in progress. Zero means no
+	// limit.
+	Ceiling time.Duration
+}
+
+// heartbeat sends InProgress for msgs until the returned function is first
+// called. That function reports whether the ceiling elapsed first.
+func (process *PullConsumerProcess) heartbeat(msgs ...jetstream.Msg) func() bool {
+	opts := process.options.Heartbeat
+	if opts == nil || process.options.Ordered {
+		return func() bool { return false }
+	}
+
+	interval := time.Duration(float64(process.ackWait()) * opts.Fraction)
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	var expired atomic.Bool
+
+	go func() {
+		defer close(exited)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		var ceiling <-chan time.Time
+		if opts.Ceiling > 0 {
+			timer := time.NewTimer(opts.Ceiling)
+			defer timer.Stop()
+			ceiling = timer.C
+		}
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ceiling:
+				expired.Store(true)
+				process.options.Logger.Warn("Handler exceeded the in-progress ceiling",
+					slog.String("subject", msgs[0].Subject()),
+					slog.Int("messages", len(msgs)),
+					slog.Duration("ceiling", opts.Ceiling),
+				)
+				return
+			case <-ticker.C:
+				for _, msg := range msgs {
+					_ = msg.InProgress()
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() bool {
+		once.Do(func() {
+			close(done)
+			<-exited
+		})
+		return expired.Load()
+	}
+}
+
+// ackWait returns the AckWait of the attached consumer
+func (process *PullConsumerProcess) ackWait() time.Duration {
+	if cons := process.pull.currentConsumer(); cons != nil {
+		if info := cons.CachedInfo(); info != nil && info.Config.AckWait > 0 {
+			return info.Config.AckWait
+		}
+	}
+	if process.options.NatsConsumerConfig.AckWait > 0 {
+		return process.options.NatsConsumerConfig.AckWait
+	}
+	return defaultAckWait
+}
+
+// ceilingExceeded replaces a handler's result once its message outlived the
+// heartbeat ceiling. Messages the handler settled itself are left alone.
+func ceilingExceeded(err error) error {
+	var settled *settledError
+	switch {
+	case err == nil:
+		return ErrHeartbeatCeiling
+	case errors.As(err, &settled):
+		return err
+	default:
+		return fmt.Errorf("%w: %v", ErrHeartbeatCeiling, err)
+	}
+}
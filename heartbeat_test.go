@@ -0,0 +1,110 @@
+package ergonats
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ergo-services/ergo/gen"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+type slowConsumer struct {
+	testConsumer
+
+	delay time.Duration
+}
+
+func (c *slowConsumer) HandleMessage(_ *PullConsumerProcess, _ jetstream.Msg) error {
+	time.Sleep(c.delay)
+	c.handled.Add(1)
+	return nil
+}
+
+func TestHeartbeatKeepsSlowHandlersInProgress(t *testing.T) {
+	shutdown, nc := startNatsServer(t)
+	defer shutdown()
+
+	js := createTestStream(t, nc, "SLOW", "slow.>")
+	_, _ = js.Publish(context.Background(), "slow.1", nil)
+
+	n := startTestNode(t)
+	defer n.Stop()
+
+	consumer := &slowConsumer{delay: 800 * time.Millisecond}
+	consumer.opts = PullConsumerOptions{
+		Connection: nc,
+		StreamName: "SLOW",
+		NatsConsumerConfig: jetstream.ConsumerConfig{
+			Durable: "slow",
+			AckWait: 300 * time.Millisecond,
+		},
+		AutoAck:   true,
+		Heartbeat: &HeartbeatOptions{},
+	}
+	if _, err := n.Spawn("slow", gen.ProcessOptions{}, consumer); err != nil {
+		t.Fatalf("failed to spawn consumer: %s", err)
+	}
+
+	waitFor(t, func() bool { return consumer.handled.Load() == 1 })
+	cons, _ := js.Consumer(context.Background(), "SLOW", "slow")
+	waitFor(t, func() bool {
+		info, err := cons.Info(context.Background())
+		return err == nil && info.NumAckPending == 0
+	})
+	info, _ := cons.Info(context.Background())
+	if info.NumRedelivered != 0 || consumer.handled.Load() != 1 {
+		t.Fatalf("slow message was redelivered: %+v", info)
+	}
+}
+
+func TestHeartbeatCeilingFailsMessage(t *testing.T) {
+	process := &PullConsumerProcess{
+		options: PullConsumerOptions{
+			Heartbeat: &HeartbeatOptions{Fraction: 0.5, Ceiling: 20 * time.Millisecond},
+			NatsConsumerConfig: jetstream.ConsumerConfig{
+				AckWait: 10 * time.Millisecond,
+			},
+		},
+		pull: newPullState(),
+	}
+	// the ceiling is the only thing logged at warning level
+	expired := &signalWriter{signal: make(chan struct{})}
+	process.options.Logger = slog.New(slog.NewTextHandler(expired, &slog.HandlerOptions{Level: slog.LevelWarn}))
+
+	msg := &fakeMsg{subject: "slow.1"}
+	stop := process.heartbeat(msg)
+	select {
+	case <-expired.signal:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("ceiling never elapsed")
+	}
+	if !stop() {
+		t.Fatalf("ceiling should have elapsed")
+	}
+	if msg.settled != "wpi" {
+		t.Fatalf("no heartbeat was sent")
+	}
+
+	err := ceilingExceeded(nil)
+	if !errors.Is(err, ErrHeartbeatCeiling) {
+		t.Fatalf("wrong error: %v", err)
+	}
+	if err := settleMessage(msg, err); err != nil || msg.settled != "nak" {
+		t.Fatalf("expired message should be naked: %v", err)
+	}
+}
+
+// signalWriter closes signal on its first write
+type signalWriter struct {
+	once   sync.Once
+	signal chan struct{}
+}
+
+func (w *signalWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() { close(w.signal) })
+	return len(p), nil
+}
@@ -0,0 +1,120 @@
+package ergonats
+
+import (
+	"context"
+	"log/slog"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// MessageHandler handles a single message. The innermost handler of a chain
+// calls the behavior's HandleMessage or HandleMessageContext.
+type MessageHandler func(ctx context.Context, process *PullConsumerProcess, msg jetstream.Msg) error
+
+// Middleware wraps a MessageHandler with cross-cutting behavior
+type Middleware func(next MessageHandler) MessageHandler
+
+// chain wraps handler with middleware so that the first one runs first
+func chain(middleware []Middleware, handler MessageHandler) MessageHandler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+// LoggingMiddleware logs every message at debug level and every failure at
+// error level, in place of the consumer's own failure log. A nil logger uses
+// the consumer's logger.
+func LoggingMiddleware(logger *slog.Logger) Middleware {
+	return func(next MessageHandler) MessageHandler {
+		return func(ctx context.Context, process *PullConsumerProcess, msg jetstream.Msg) error {
+			log := logger
+			if log == nil {
+				log = process.Options().Logger
+			}
+
+			start := time.Now()
+			err := next(ctx, process, msg)
+			attrs := []any{
+				slog.String("subject", msg.Subject()),
+				slog.Duration("duration", time.Since(start)),
+			}
+			if err != nil {
+				log.Error("Message failed", append(attrs, slog.Any("error", err))...)
+				process.logged = true
+			} else {
+				log.Debug("Message handled", attrs...)
+			}
+			return err
+		}
+	}
+}
+
+// MetricsRecorder receives one observation per handled message
+type MetricsRecorder interface {
+	ObserveMessage(subject string, duration time.Duration, err error)
+}
+
+// MetricsMiddleware times every message and reports it to recorder
+func MetricsMiddleware(recorder MetricsRecorder) Middleware {
+	return func(next MessageHandler) MessageHandler {
+		return func(ctx context.Context, process *PullConsumerProcess, msg jetstream.Msg) error {
+			start := time.Now()
+			err := next(ctx, process, msg)
+			recorder.ObserveMessage(msg.Subject(), time.Since(start), err)
+			return err
+		}
+	}
+}
+
+// MessageMetrics is a MetricsRecorder keeping totals in memory
+type MessageMetrics struct {
+	handled  atomic.Uint64
+	failed   atomic.Uint64
+	duration atomic.Int64
+}
+
+// MessageMetricsSnapshot is a point-in-time copy of MessageMetrics
+type MessageMetricsSnapshot struct {
+	Handled  uint64
+	Failed   uint64
+	Duration time.Duration
+}
+
+func (m *MessageMetrics) ObserveMessage(_ string, duration time.Duration, err error) {
+	if err != nil {
+		m.failed.Add(1)
+	} else {
+		m.handled.Add(1)
+	}
+	m.duration.Add(int64(duration))
+}
+
+func (m *MessageMetrics) Snapshot() MessageMetricsSnapshot {
+	return MessageMetricsSnapshot{
+		Handled:  m.handled.Load(),
+		Failed:   m.failed.Load(),
+		Duration: time.Duration(m.duration.Load()),
+	}
+}
+
+// RecoveryMiddleware turns a panic in the rest of the chain into a PanicError,
+// which is then settled like any other failure. Unlike RecoverPolicy it leaves
+// the message to AutoAck or to the middleware further out.
+func RecoveryMiddleware() Middleware {
+	return func(next MessageHandler) MessageHandler {
+		return func(ctx context.Context, process *PullConsumerProcess, msg jetstream.Msg) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					panicErr := &PanicError{Value: r, Stack: debug.Stack()}
+					process.logPanic(msg, panicErr)
+					err = panicErr
+				}
+			}()
+			return next(ctx, process, msg)
+		}
+	}
+}
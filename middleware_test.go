@@ -0,0 +1,78 @@
+package ergonats
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestMiddlewareChain(t *testing.T) {
+	var order []string
+	trace := func(name string) Middleware {
+		return func(next MessageHandler) MessageHandler {
+			return func(ctx context.Context, process *PullConsumerProcess, msg jetstream.Msg) error {
+				order = append(order, name)
+				return next(ctx, process, msg)
+			}
+		}
+	}
+
+	metrics := &MessageMetrics{}
+	handler := chain([]Middleware{
+		trace("outer"),
+		MetricsMiddleware(metrics),
+		RecoveryMiddleware(),
+		trace("inner"),
+	}, func(_ context.Context, _ *PullConsumerProcess, msg jetstream.Msg) error {
+		if msg.Subject() == "orders.poison" {
+			panic("poison")
+		}
+		return nil
+	})
+
+	process := &PullConsumerProcess{options: PullConsumerOptions{Logger: slog.Default()}}
+	if err := handler(context.Background(), process, &fakeMsg{subject: "orders.1"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	err := handler(context.Background(), process, &fakeMsg{subject: "orders.poison"})
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) {
+		t.Fatalf("panic was not recovered: %v", err)
+	}
+
+	if len(order) != 4 || order[0] != "outer" || order[1] != "inner" {
+		t.Fatalf("middleware ran in the wrong order: %v", order)
+	}
+	if snapshot := metrics.Snapshot(); snapshot.Handled != 1 || snapshot.Failed != 1 {
+		t.Fatalf("wrong metrics: %+v", snapshot)
+	}
+}
+
+func TestLoggingMiddlewareLogsFailuresOnce(t *testing.T) {
+	var out bytes.Buffer
+	process := &PullConsumerProcess{
+		options: PullConsumerOptions{Logger: slog.New(slog.NewTextHandler(&out, nil))},
+		pull:    newPullState(),
+	}
+	handler := chain([]Middleware{LoggingMiddleware(nil)}, func(_ context.Context, _ *PullConsumerProcess, _ jetstream.Msg) error {
+		return errors.New("boom")
+	})
+
+	msg := &fakeMsg{subject: "orders.1"}
+	process.record(msg, handler(context.Background(), process, msg))
+	if n := strings.Count(out.String(), "boom"); n != 1 {
+		t.Fatalf("failure was logged %d times:\n%s", n, out.String())
+	}
+
+	// without the middleware the consumer logs the failure itself
+	out.Reset()
+	process.record(msg, errors.New("boom"))
+	if n := strings.Count(out.String(), "boom"); n != 1 {
+		t.Fatalf("failure was logged %d times:\n%s", n, out.String())
+	}
+}
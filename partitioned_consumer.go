@@ -0,0 +1,382 @@
+package ergonats
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+
+	"github.com/ergo-services/ergo/etf"
+	"github.com/ergo-services/ergo/gen"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// PartitionKeyFunc extracts the key that decides which partition handles a
+// message
+type PartitionKeyFunc func(msg jetstream.Msg) string
+
+// SubjectTokenKey partitions by the subject token at index (zero-based). The
+// whole subject is used when the subject has fewer tokens.
+func SubjectTokenKey(index int) PartitionKeyFunc {
+	return func(msg jetstream.Msg) string {
+		tokens := strings.Split(msg.Subject(), ".")
+		if index < 0 || index >= len(tokens) {
+			return msg.Subject()
+		}
+		return tokens[index]
+	}
+}
+
+// HeaderKey partitions by the value of a message header
+func HeaderKey(name string) PartitionKeyFunc {
+	return func(msg jetstream.Msg) string {
+		return msg.Headers().Get(name)
+	}
+}
+
+const defaultPartitionInFlight = 16
+
+type PartitionedConsumerBehavior interface {
+	gen.SupervisorBehavior
+
+	InitPartitionedConsumer(args ...etf.Term) (*PartitionedConsumerOptions, error)
+	HandlePartitionMessage(process *PartitionWorkerProcess, msg jetstream.Msg) error
+}
+
+// PartitionedConsumer is a supervisor that runs a router pull consumer and a
+// fixed number of partition workers. The router sends every message with the
+// same key to the same worker, in stream order, and the worker handles it the
+// way a PullConsumer would: through the middleware, heartbeats, panic recovery,
+// the retry policy and AutoAck. The router waits while a worker already holds
+// PartitionInFlight messages rather than letting later messages for its keys
+// overtake. A nak still lets later messages with the same key overtake the
+// redelivery.
+type PartitionedConsumer struct {
+	gen.Supervisor
+
+	behavior PartitionedConsumerBehavior
+}
+
+type PartitionedConsumerOptions struct {
+	PullConsumerOptions
+
+	// Name prefixes the registered names of the router and the workers
+	Name       string
+	Partitions int
+	// Key defaults to the whole subject
+	Key PartitionKeyFunc
+	// PartitionInFlight bounds the messages routed to a worker but not yet
+	// handled by it. Defaults to 16.
+	PartitionInFlight int
+}
+
+// PartitionWorkerProcess is the process handed to HandlePartitionMessage
+type PartitionWorkerProcess struct {
+	gen.ServerProcess
+
+	partition int
+	options   *PartitionedConsumerOptions
+	pipeline  *PullConsumerProcess
+	window    *partitionWindow
+}
+
+func (p *PartitionWorkerProcess) Partition() int {
+	return p.partition
+}
+
+func (p *PartitionWorkerProcess) Options() *PartitionedConsumerOptions {
+	return p.options
+}
+
+// Delivery returns the delivery info of the message being handled
+func (p *PartitionWorkerProcess) Delivery() *DeliveryInfo {
+	return p.pipeline.Delivery()
+}
+
+// PartitionWorkerName returns the registered name of a partition's worker
+func PartitionWorkerName(name string, partition int) string {
+	return fmt.Sprintf("%s_partition_%d", name, partition)
+}
+
+// PartitionRouterName returns the registered name of the router
+func PartitionRouterName(name string) string {
+	return fmt.Sprintf("%s_router", name)
+}
+
+// ProcessInit captures the behavior so that Init can reach it
+func (c *PartitionedConsumer) ProcessInit(p gen.Process, args ...etf.Term) (gen.ProcessState, error) {
+	behavior, ok := p.Behavior().(PartitionedConsumerBehavior)
+	if !ok {
+		return gen.ProcessState{}, fmt.Errorf("partitioned consumer: not a PartitionedConsumerBehavior")
+	}
+	c.behavior = behavior
+	return c.Supervisor.ProcessInit(p, args...)
+}
+
+func (c *PartitionedConsumer) Init(args ...etf.Term) (gen.SupervisorSpec, error) {
+	opts, err := c.behavior.InitPartitionedConsumer(args...)
+	if err != nil {
+		return gen.SupervisorSpec{}, err
+	}
+	if err := opts.validate(); err != nil {
+		return gen.SupervisorSpec{}, err
+	}
+	if opts.Key == nil {
+		opts.Key = func(msg jetstream.Msg) string { return msg.Subject() }
+	}
+	if opts.PartitionInFlight == 0 {
+		opts.PartitionInFlight = defaultPartitionInFlight
+	}
+	opts.setDefaults()
+
+	windows := newPartitionWindows(opts.Partitions, opts.PartitionInFlight)
+	children := make([]gen.SupervisorChildSpec, 0, opts.Partitions+1)
+	for i := 0; i < opts.Partitions; i++ {
+		children = append(children, gen.SupervisorChildSpec{
+			Name:  PartitionWorkerName(opts.Name, i),
+			Child: &partitionWorker{behavior: c.behavior, options: opts, windows: windows},
+			Args:  []etf.Term{i},
+		})
+	}
+	children = append(children, gen.SupervisorChildSpec{
+		Name:  PartitionRouterName(opts.Name),
+		Child: &partitionRouter{options: opts, windows: windows},
+	})
+
+	return gen.SupervisorSpec{
+		Name:     opts.Name,
+		Children: children,
+		Strategy: gen.SupervisorStrategy{
+			Type:      gen.SupervisorStrategyOneForOne,
+			Intensity: gen.SupervisorRestartIntensity,
+			Period:    gen.SupervisorRestartPeriod,
+			Restart:   gen.SupervisorStrategyRestartTransient,
+		},
+	}, nil
+}
+
+// validate rejects the options that only make sense when messages are handled
+// in stream order by the process that pulls them
+func (opts *PartitionedConsumerOptions) validate() error {
+	if opts.Partitions <= 0 {
+		return invalidOption("partitioned consumer", "Partitions", "must be positive")
+	}
+	if strings.TrimSpace(opts.Name) == "" {
+		return invalidOption("partitioned consumer", "Name", "a name is required to register partition workers")
+	}
+	if opts.PartitionInFlight < 0 {
+		return invalidOption("partitioned consumer", "PartitionInFlight", "must not be negative")
+	}
+	if opts.Ordered {
+		return invalidOption("partitioned consumer", "Ordered", "partitions handle messages out of stream order")
+	}
+	if opts.Dedupe != nil {
+		return invalidOption("partitioned consumer", "Dedupe", "partitions handle messages out of stream order")
+	}
+	if opts.BatchSize > 0 {
+		return invalidOption("partitioned consumer", "BatchSize", "workers handle one message at a time")
+	}
+	if opts.Router != nil {
+		return invalidOption("partitioned consumer", "Router", "workers hand every message to HandlePartitionMessage")
+	}
+	if opts.ConnectionEvents != nil {
+		return invalidOption("partitioned consumer", "ConnectionEvents", "the router has no info handler to deliver them to")
+	}
+	return opts.PullConsumerOptions.validate(&partitionRouter{})
+}
+
+// partitionWindow holds the credits of one worker. The router takes one for
+// each message it routes to the worker and the worker gives it back once the
+// message is handled. closed is closed when the worker stops.
+type partitionWindow struct {
+	credits chan struct{}
+	closed  chan struct{}
+	once    sync.Once
+}
+
+func (w *partitionWindow) close() {
+	w.once.Do(func() { close(w.closed) })
+}
+
+func (w *partitionWindow) release() {
+	select {
+	case <-w.credits:
+	default:
+	}
+}
+
+// partitionWindows is shared by the router and the workers of a partitioned
+// consumer. A worker opens a fresh window every time it starts, since the
+// messages in the mailbox of a worker that stopped are gone.
+type partitionWindows struct {
+	sync.Mutex
+
+	size     int
+	windows  []*partitionWindow
+	failures *failureLog
+}
+
+func newPartitionWindows(partitions, size int) *partitionWindows {
+	windows := &partitionWindows{
+		size:     size,
+		windows:  make([]*partitionWindow, partitions),
+		failures: newFailureLog(),
+	}
+	for i := range windows.windows {
+		windows.windows[i] = windows.newWindow()
+	}
+	return windows
+}
+
+func (pw *partitionWindows) newWindow() *partitionWindow {
+	return &partitionWindow{
+		credits: make(chan struct{}, pw.size),
+		closed:  make(chan struct{}),
+	}
+}
+
+// open replaces a partition's window, closing the previous one
+func (pw *partitionWindows) open(partition int) *partitionWindow {
+	pw.Lock()
+	defer pw.Unlock()
+	pw.windows[partition].close()
+	pw.windows[partition] = pw.newWindow()
+	return pw.windows[partition]
+}
+
+func (pw *partitionWindows) get(partition int) *partitionWindow {
+	pw.Lock()
+	defer pw.Unlock()
+	return pw.windows[partition]
+}
+
+// routedMessage is cast by the router to a worker along with the consumer the
+// message was pulled from
+type routedMessage struct {
+	msg      jetstream.Msg
+	consumer jetstream.Consumer
+}
+
+// partitionRouter pulls from JetStream and forwards each message to the
+// worker that owns its key
+type partitionRouter struct {
+	PullConsumer
+
+	options *PartitionedConsumerOptions
+	windows *partitionWindows
+}
+
+func (r *partitionRouter) InitPullConsumer(
+	process *PullConsumerProcess,
+	_ ...etf.Term) (*PullConsumerOptions, error) {
+
+	// the router dead-letters the messages whose failures the workers record
+	process.pull.failures = r.windows.failures
+
+	opts := r.options.PullConsumerOptions
+	// the retry schedule may be the consumer's BackOff
+	opts.NatsConsumerConfig = opts.consumerConfig()
+	// workers handle and settle messages
+	opts.AutoAck = false
+	opts.Retry = nil
+	opts.Heartbeat = nil
+	opts.Middleware = nil
+	opts.RecoverPolicy = RecoverCrash
+	return &opts, nil
+}
+
+// HandleMessage waits for a credit from the message's worker and casts the
+// message to it. Waiting blocks every other key, but routing around a busy
+// worker would let later messages for its keys overtake.
+func (r *partitionRouter) HandleMessage(process *PullConsumerProcess, msg jetstream.Msg) error {
+	partition := partitionFor(r.options.Key(msg), r.options.Partitions)
+	worker := PartitionWorkerName(r.options.Name, partition)
+
+	for {
+		window := r.windows.get(partition)
+		select {
+		case window.credits <- struct{}{}:
+			routed := routedMessage{msg: msg, consumer: process.pull.currentConsumer()}
+			if err := process.Cast(worker, routed); err != nil {
+				window.release()
+				_ = msg.Nak()
+				return fmt.Errorf("failed to route message to %s: %w", worker, err)
+			}
+			return nil
+		case <-window.closed:
+			if r.windows.get(partition) != window {
+				// the worker restarted
+				continue
+			}
+			_ = msg.Nak()
+			return fmt.Errorf("failed to route message to %s: worker is not running", worker)
+		case <-process.Context().Done():
+			_ = msg.Nak()
+			return process.Context().Err()
+		}
+	}
+}
+
+func partitionFor(key string, partitions int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % uint32(partitions))
+}
+
+// partitionWorker handles the messages routed to a single partition
+type partitionWorker struct {
+	gen.Server
+
+	behavior PartitionedConsumerBehavior
+	options  *PartitionedConsumerOptions
+	windows  *partitionWindows
+}
+
+func (w *partitionWorker) Init(process *gen.ServerProcess, args ...etf.Term) error {
+	partition, ok := args[0].(int)
+	if !ok {
+		return fmt.Errorf("partition worker: partition must be an int")
+	}
+	p := &PartitionWorkerProcess{
+		ServerProcess: *process,
+		partition:     partition,
+		options:       w.options,
+	}
+	// messages go through the same pipeline as in a PullConsumer
+	p.pipeline = &PullConsumerProcess{
+		ServerProcess: *process,
+		options:       w.options.PullConsumerOptions,
+		pull:          newPullState(),
+	}
+	p.pipeline.pull.failures = w.windows.failures
+	p.pipeline.handler = chain(w.options.Middleware,
+		func(_ context.Context, _ *PullConsumerProcess, msg jetstream.Msg) error {
+			return w.behavior.HandlePartitionMessage(p, msg)
+		})
+	p.window = w.windows.open(partition)
+	process.State = p
+	return nil
+}
+
+func (w *partitionWorker) HandleCast(process *gen.ServerProcess, message etf.Term) gen.ServerStatus {
+	routed, ok := message.(routedMessage)
+	if !ok {
+		return gen.ServerStatusOK
+	}
+	p := process.State.(*PartitionWorkerProcess)
+	defer p.window.release()
+
+	if routed.consumer != nil {
+		p.pipeline.pull.setConsumer(routed.consumer)
+	}
+	p.pipeline.handleMessage(routed.msg)
+
+	return gen.ServerStatusOK
+}
+
+func (w *partitionWorker) Terminate(process *gen.ServerProcess, _ string) {
+	if p, ok := process.State.(*PartitionWorkerProcess); ok {
+		p.window.close()
+	}
+}
@@ -0,0 +1,221 @@
+package ergonats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ergo-services/ergo/etf"
+	"github.com/ergo-services/ergo/gen"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+type testPartitionedConsumer struct {
+	PartitionedConsumer
+
+	opts PartitionedConsumerOptions
+
+	mu         sync.Mutex
+	partitions map[string]map[int]bool
+	order      map[string][]string
+}
+
+func (c *testPartitionedConsumer) InitPartitionedConsumer(_ ...etf.Term) (*PartitionedConsumerOptions, error) {
+	return &c.opts, nil
+}
+
+func (c *testPartitionedConsumer) HandlePartitionMessage(process *PartitionWorkerProcess, msg jetstream.Msg) error {
+	key := c.opts.Key(msg)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.partitions[key] == nil {
+		c.partitions[key] = map[int]bool{}
+	}
+	c.partitions[key][process.Partition()] = true
+	c.order[key] = append(c.order[key], string(msg.Data()))
+	return nil
+}
+
+func (c *testPartitionedConsumer) handled() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, seen := range c.order {
+		n += len(seen)
+	}
+	return n
+}
+
+func TestPartitionedConsumerKeepsKeyOrder(t *testing.T) {
+	shutdown, nc := startNatsServer(t)
+	defer shutdown()
+
+	js := createTestStream(t, nc, "ACCOUNTS", "accounts.>")
+	for i := 0; i < 5; i++ {
+		for _, account := range []string{"a", "b", "c", "d", "e", "f"} {
+			subject := fmt.Sprintf("accounts.%s.deposited", account)
+			_, _ = js.Publish(context.Background(), subject, []byte(fmt.Sprint(i)))
+		}
+	}
+
+	n := startTestNode(t)
+	defer n.Stop()
+
+	consumer := &testPartitionedConsumer{
+		opts: PartitionedConsumerOptions{
+			PullConsumerOptions: PullConsumerOptions{
+				Connection: nc,
+				StreamName: "ACCOUNTS",
+				NatsConsumerConfig: jetstream.ConsumerConfig{
+					Durable: "accounts",
+				},
+				AutoAck: true,
+			},
+			Name:       "accounts",
+			Partitions: 3,
+			Key:        SubjectTokenKey(1),
+		},
+		partitions: map[string]map[int]bool{},
+		order:      map[string][]string{},
+	}
+	if _, err := n.Spawn("accounts", gen.ProcessOptions{}, consumer); err != nil {
+		t.Fatalf("failed to spawn partitioned consumer: %s", err)
+	}
+
+	waitFor(t, func() bool { return consumer.handled() == 30 })
+
+	consumer.mu.Lock()
+	for key, seen := range consumer.order {
+		if len(consumer.partitions[key]) != 1 {
+			t.Fatalf("key %s was handled by several partitions: %v", key, consumer.partitions[key])
+		}
+		for i, data := range seen {
+			if data != fmt.Sprint(i) {
+				t.Fatalf("key %s handled out of order: %v", key, seen)
+			}
+		}
+	}
+	consumer.mu.Unlock()
+
+	cons, _ := js.Consumer(context.Background(), "ACCOUNTS", "accounts")
+	waitFor(t, func() bool {
+		info, err := cons.Info(context.Background())
+		return err == nil && info.NumAckPending == 0 && info.NumPending == 0
+	})
+}
+
+type slowPartitionedConsumer struct {
+	PartitionedConsumer
+
+	opts PartitionedConsumerOptions
+
+	mu      sync.Mutex
+	handled []string
+	failed  bool
+}
+
+func (c *slowPartitionedConsumer) InitPartitionedConsumer(_ ...etf.Term) (*PartitionedConsumerOptions, error) {
+	return &c.opts, nil
+}
+
+func (c *slowPartitionedConsumer) HandlePartitionMessage(_ *PartitionWorkerProcess, msg jetstream.Msg) error {
+	time.Sleep(time.Millisecond)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if string(msg.Data()) == "3" && !c.failed {
+		c.failed = true
+		return errors.New("first attempt fails")
+	}
+	c.handled = append(c.handled, string(msg.Data()))
+	return nil
+}
+
+func (c *slowPartitionedConsumer) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.handled)
+}
+
+func TestPartitionedConsumerRetriesWithBackpressure(t *testing.T) {
+	shutdown, nc := startNatsServer(t)
+	defer shutdown()
+
+	js := createTestStream(t, nc, "LEDGER", "ledger.>")
+	for i := 0; i < 200; i++ {
+		_, _ = js.Publish(context.Background(), "ledger.a", []byte(fmt.Sprint(i)))
+	}
+
+	n := startTestNode(t)
+	defer n.Stop()
+
+	consumer := &slowPartitionedConsumer{
+		opts: PartitionedConsumerOptions{
+			PullConsumerOptions: PullConsumerOptions{
+				Connection: nc,
+				StreamName: "LEDGER",
+				NatsConsumerConfig: jetstream.ConsumerConfig{
+					Durable: "ledger",
+				},
+				AutoAck: true,
+				Retry:   &RetryPolicy{Delays: []time.Duration{10 * time.Millisecond}},
+			},
+			Name:              "ledger",
+			Partitions:        2,
+			PartitionInFlight: 2,
+		},
+	}
+	if _, err := n.Spawn("ledger", gen.ProcessOptions{}, consumer); err != nil {
+		t.Fatalf("failed to spawn partitioned consumer: %s", err)
+	}
+
+	// every message has the same key, so one worker handles them all
+	worker := n.ProcessByName(PartitionWorkerName("ledger", partitionFor("ledger.a", 2)))
+	deadline := time.Now().Add(10 * time.Second)
+	for consumer.count() < 200 {
+		if time.Now().After(deadline) {
+			t.Fatalf("handled %d of 200 messages", consumer.count())
+		}
+		if queued := worker.Info().MessageQueueLen; queued > 2 {
+			t.Fatalf("worker mailbox holds %d messages, more than PartitionInFlight", queued)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cons, _ := js.Consumer(context.Background(), "LEDGER", "ledger")
+	waitFor(t, func() bool {
+		info, err := cons.Info(context.Background())
+		return err == nil && info.NumAckPending == 0 && info.NumPending == 0
+	})
+}
+
+func TestPartitionedConsumerRejectsRouterOnlyOptions(t *testing.T) {
+	shutdown, nc := startNatsServer(t)
+	defer shutdown()
+
+	n := startTestNode(t)
+	defer n.Stop()
+
+	consumer := &testPartitionedConsumer{
+		opts: PartitionedConsumerOptions{
+			PullConsumerOptions: PullConsumerOptions{
+				Connection: nc,
+				StreamName: "LEDGER",
+				NatsConsumerConfig: jetstream.ConsumerConfig{
+					Durable:       "ledger",
+					MaxAckPending: 1,
+				},
+				Dedupe: &DedupeOptions{Bucket: "ledger"},
+			},
+			Name:       "ledger",
+			Partitions: 2,
+		},
+	}
+	_, err := n.Spawn("ledger", gen.ProcessOptions{}, consumer)
+	var optsErr *OptionsError
+	if !errors.As(err, &optsErr) || optsErr.Component != "partitioned consumer" || optsErr.Field != "Dedupe" {
+		t.Fatalf("expected Dedupe to be rejected, got %v", err)
+	}
+}
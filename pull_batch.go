@@ -0,0 +1,210 @@
+package ergonats
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+var ErrNoBatchHandler = errors.New("consumer: BatchSize set but behavior does not implement HandleBatch")
+
+// PullConsumerBatchHandler is an optional extension of PullConsumerBehavior.
+// When BatchSize is set, messages are fetched in batches and handed to
+// HandleBatch instead of HandleMessage.
+type PullConsumerBatchHandler interface {
+	HandleBatch(process *PullConsumerProcess, msgs []jetstream.Msg) error
+}
+
+// BatchError reports per-message results from HandleBatch. Errors is indexed
+// like the batch and each entry settles its message the same way an error
+// returned from HandleMessage would.
+type BatchError struct {
+	Errors []error
+}
+
+func (e *BatchError) Error() string {
+	failed := 0
+	for _, err := range e.Errors {
+		if err != nil {
+			failed++
+		}
+	}
+	return fmt.Sprintf("%d of %d messages in batch failed", failed, len(e.Errors))
+}
+
+// errorFor returns the result for the message at index i
+func (e *BatchError) errorFor(i int) error {
+	if i < len(e.Errors) {
+		return e.Errors[i]
+	}
+	return nil
+}
+
+// fetchLoop lets a running fetchBatches loop be stopped through the
+// pullSubscription interface
+type fetchLoop struct {
+	once sync.Once
+	done chan struct{}
+}
+
+func (f *fetchLoop) Stop() {
+	f.once.Do(func() { close(f.done) })
+}
+
+func (f *fetchLoop) Drain() {
+	f.Stop()
+}
+
+// fetchBatches fetches up to BatchSize messages at a time and only fetches
+// the next batch once the process has handled the previous one
+func (process *PullConsumerProcess) fetchBatches(cons jetstream.Consumer) error {
+	// a loop that was paused may still be fetching, and two loops must never
+	// fetch at the same time
+	done, previous := process.pull.handOver()
+	defer close(done)
+	if previous != nil {
+		select {
+		case <-previous:
+		case <-process.pull.quit:
+			return nil
+		}
+	}
+
+	loop := &fetchLoop{done: make(chan struct{})}
+	process.pull.setSubscription(loop)
+	_ = process.sendSelf(messagePullAttached{})
+
+	for {
+		select {
+		case process.credits <- struct{}{}:
+		case <-loop.done:
+			return nil
+		case <-process.pull.quit:
+			return nil
+		}
+		// the credit may have been picked over a pause that raced with it
+		select {
+		case <-loop.done:
+			process.releaseCredit()
+			return nil
+		case <-process.pull.quit:
+			process.releaseCredit()
+			return nil
+		default:
+		}
+
+		batch, err := cons.Fetch(process.options.BatchSize,
+			jetstream.FetchMaxWait(process.options.BatchMaxWait))
+		if err != nil {
+			process.releaseCredit()
+			if isFatalConsumeError(err) {
+				return fmt.Errorf("fetch failed: %w", err)
+			}
+			process.options.Logger.Warn("Failed to fetch batch",
+				slog.String("consumer", process.options.NatsConsumerConfig.Name),
+				slog.Any("error", err),
+			)
+			continue
+		}
+
+		msgs := make([]jetstream.Msg, 0, process.options.BatchSize)
+		for msg := range batch.Messages() {
+			msgs = append(msgs, msg)
+		}
+		if err := batch.Error(); err != nil && isFatalConsumeError(err) {
+			for _, msg := range msgs {
+				_ = msg.Nak()
+			}
+			process.releaseCredit()
+			return fmt.Errorf("fetch failed: %w", err)
+		}
+
+		if len(msgs) == 0 || !process.deliverBatch(msgs) {
+			process.releaseCredit()
+		}
+	}
+}
+
+// deliverBatch casts msgs to the process as a single message, naking all of
+// them if the process is stopping or can no longer receive messages
+func (process *PullConsumerProcess) deliverBatch(msgs []jetstream.Msg) bool {
+	for i, msg := range msgs {
+		if !process.pull.track(msg) {
+			for _, m := range msgs[:i] {
+				process.pull.untrack(m)
+			}
+			nakAll(msgs)
+			return false
+		}
+	}
+	if err := process.castSelf(msgs); err != nil {
+		for _, msg := range msgs {
+			process.pull.untrack(msg)
+		}
+		nakAll(msgs)
+		return false
+	}
+	process.pull.delivered.Add(uint64(len(msgs)))
+	return true
+}
+
+func (process *PullConsumerProcess) handleBatch(msgs []jetstream.Msg) {
+	for _, msg := range msgs {
+		process.pull.untrack(msg)
+	}
+	if !process.pull.begin() {
+		nakAll(msgs)
+		return
+	}
+	defer process.pull.end()
+
+	handler, ok := process.behavior.(PullConsumerBatchHandler)
+	if !ok {
+		process.options.Logger.Error("Failed to handle batch", slog.Any("error", ErrNoBatchHandler))
+		nakAll(msgs)
+		return
+	}
+
+	pending := msgs[:0:0]
+	for _, msg := range msgs {
+		if _, ok := process.processing(msg); ok {
+			pending = append(pending, msg)
+		}
+	}
+	if len(pending) == 0 {
+		return
+	}
+	msgs = pending
+
+	stop := process.heartbeat(msgs...)
+	defer stop()
+	err := process.protect(msgs, func() error {
+		return handler.HandleBatch(process, msgs)
+	})
+	expired := stop()
+
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) {
+		batchErr = nil
+	}
+	for i, msg := range msgs {
+		msgErr := err
+		if batchErr != nil {
+			msgErr = batchErr.errorFor(i)
+		}
+		if expired {
+			msgErr = ceilingExceeded(msgErr)
+		}
+		process.record(msg, msgErr)
+		process.settle(msg, msgErr)
+	}
+}
+
+func nakAll(msgs []jetstream.Msg) {
+	for _, msg := range msgs {
+		_ = msg.Nak()
+	}
+}
@@ -2,9 +2,11 @@ package ergonats
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"strings"
+	"time"
 
 	"github.com/ergo-services/ergo/etf"
 	"github.com/ergo-services/ergo/gen"
@@ -12,6 +14,26 @@ import (
 	"github.com/nats-io/nats.go/jetstream"
 )
 
+const (
+	defaultShutdownTimeout = 5 * time.Second
+	defaultBatchMaxWait    = 5 * time.Second
+)
+
+var errPullStopping = errors.New("pull consumer is stopping")
+
+// messagePullAttached is sent by the pulling goroutine once the consumer is
+// attached and delivering messages
+type messagePullAttached struct{}
+
+// messagePullFailed is sent by the pulling goroutine when it can no longer
+// deliver messages to the process
+type messagePullFailed struct {
+	err error
+}
+
+// messagePullRetry asks the process to attach to JetStream again
+type messagePullRetry struct{}
+
 type PullConsumerBehavior interface {
 	gen.ServerBehavior
 
@@ -19,6 +41,24 @@ type PullConsumerBehavior interface {
 	HandleMessage(process *PullConsumerProcess, msg jetstream.Msg) error
 }
 
+// PullConsumerCallHandler is an optional extension of PullConsumerBehavior for
+// answering synchronous requests made with Call
+type PullConsumerCallHandler interface {
+	HandlePullConsumerCall(process *PullConsumerProcess, from gen.ServerFrom, message etf.Term) (etf.Term, gen.ServerStatus)
+}
+
+// PullConsumerInfoHandler is an optional extension of PullConsumerBehavior for
+// receiving regular messages such as timers and monitor notifications
+type PullConsumerInfoHandler interface {
+	HandlePullConsumerInfo(process *PullConsumerProcess, message etf.Term) gen.ServerStatus
+}
+
+// PullConsumerDirectHandler is an optional extension of PullConsumerBehavior
+// for answering direct requests made with Direct
+type PullConsumerDirectHandler interface {
+	HandlePullConsumerDirect(process *PullConsumerProcess, ref etf.Ref, message interface{}) (interface{}, gen.DirectStatus)
+}
+
 type PullConsumer struct {
 	gen.Server
 }
@@ -29,6 +69,88 @@ type PullConsumerOptions struct {
 	JsDomain           string
 	StreamName         string
 	NatsConsumerConfig jetstream.ConsumerConfig
+
+	// StreamConfig, when set, declares the stream being consumed. It is
+	// created if it does not exist, and an existing stream that drifted from
+	// it is handled according to StreamDriftPolicy. StreamName defaults to
+	// StreamConfig.Name.
+	StreamConfig      *jetstream.StreamConfig
+	StreamDriftPolicy StreamDriftPolicy
+
+	// AutoAck settles each message based on the error returned from
+	// HandleMessage: nil acks, RetryAfter naks with a delay, Terminate terms,
+	// ErrInProgress extends the ack deadline and any other error naks.
+	AutoAck bool
+
+	// MaxInFlight bounds the number of messages that have been pulled from
+	// JetStream but not yet handled by the process. Messages are then pulled
+	// one at a time, as credits free up. Zero means unbounded.
+	MaxInFlight int
+
+	// ShutdownTimeout bounds how long Terminate waits for in-flight handlers
+	// before naking every message that was not processed. Defaults to 5s.
+	ShutdownTimeout time.Duration
+
+	// BatchSize switches the consumer into batch mode: messages are fetched
+	// up to BatchSize at a time and handed to the behavior's HandleBatch,
+	// which must be implemented. BatchMaxWait bounds how long a fetch waits
+	// to fill a batch and defaults to 5s.
+	BatchSize    int
+	BatchMaxWait time.Duration
+
+	// Ordered attaches an ephemeral, automatically recreated JetStream ordered
+	// consumer configured by OrderedConsumerConfig instead of creating the
+	// durable consumer in NatsConsumerConfig. Ordered consumers need no
+	// acknowledgements. ResumeSequence is the last stream sequence that was
+	// already processed; delivery resumes right after it.
+	Ordered               bool
+	OrderedConsumerConfig jetstream.OrderedConsumerConfig
+	ResumeSequence        uint64
+
+	// AttachRetry, when set, re-attaches to JetStream with exponential backoff
+	// after an attachment or consume failure. When nil, or once the retries
+	// are exhausted, the process stops with the failure as its reason.
+	AttachRetry *Backoff
+
+	// DeadLetter, when set, watches the consumer's max-deliveries advisories
+	// and republishes every message that exhausts MaxDeliver to a dead-letter
+	// subject along with its failure metadata
+	DeadLetter *DeadLetterOptions
+
+	// Bind attaches to the existing durable consumer named in
+	// NatsConsumerConfig instead of creating or updating it, so that many
+	// processes can share a consumer provisioned once, as PullConsumerPool does
+	Bind bool
+
+	// Heartbeat, when set, sends InProgress for each message while its
+	// handler runs so that slow handlers do not see it redelivered
+	Heartbeat *HeartbeatOptions
+
+	// RecoverPolicy decides what happens to a message whose handler panics.
+	// The default lets the panic stop the process. RecoverDelay is the
+	// redelivery delay used by RecoverNak.
+	RecoverPolicy RecoverPolicy
+	RecoverDelay  time.Duration
+
+	// Middleware wraps the handling of every message, the first entry being
+	// the outermost. Batches bypass it.
+	Middleware []Middleware
+
+	// Router, when set, handles every message by subject instead of the
+	// behavior's HandleMessage
+	Router *Router
+
+	// Dedupe, when set, skips messages that were already processed according
+	// to the last stream sequence persisted in a KV bucket
+	Dedupe *DedupeOptions
+
+	// Retry, when set, schedules the redelivery of failed messages by their
+	// delivery count. It requires AutoAck.
+	Retry *RetryPolicy
+
+	// ConnectionEvents, when set, delivers the events of the NATS connection
+	// to HandlePullConsumerInfo while the process runs
+	ConnectionEvents *ConnectionEvents
 }
 
 type PullConsumerProcess struct {
@@ -36,6 +158,17 @@ type PullConsumerProcess struct {
 
 	options  PullConsumerOptions
 	behavior PullConsumerBehavior
+	handler  MessageHandler
+	delivery *DeliveryInfo
+	// logged is set by LoggingMiddleware once it has logged the failure of
+	// the message being handled
+	logged   bool
+	credits  chan struct{}
+	pull     *pullState
+	attempts int
+
+	// typed holds the *TypedPullConsumerOptions of a TypedPullConsumer
+	typed interface{}
 }
 
 func (pcp *PullConsumerProcess) Options() *PullConsumerOptions {
@@ -50,6 +183,7 @@ func (c *PullConsumer) Init(
 
 	consumerProcess := &PullConsumerProcess{
 		ServerProcess: *process,
+		pull:          newPullState(),
 	}
 	consumerProcess.State = nil
 
@@ -59,35 +193,87 @@ func (c *PullConsumer) Init(
 	}
 	consumerProcess.behavior = behavior
 
+	args, member := joinPool(args)
+	if member != nil {
+		consumerProcess.pull.failures = member.failures
+	}
+
 	consumerOpts, err := behavior.InitPullConsumer(consumerProcess, args...)
 	if err != nil {
 		return err
 	}
 
-	if err := consumerOpts.validate(); err != nil {
-		return err
+	if consumerOpts.StreamConfig != nil && consumerOpts.StreamName == "" {
+		consumerOpts.StreamName = consumerOpts.StreamConfig.Name
 	}
-	if consumerOpts.Logger == nil {
-		consumerOpts.Logger = slog.Default()
+	if err := consumerOpts.validate(behavior); err != nil {
+		return err
 	}
+	consumerOpts.setDefaults()
 
 	consumerOpts.Logger.Info("Initializing pull consumer", slog.Any("pid", process.Info().PID),
 		slog.String("process_name", process.Name()))
 
 	consumerProcess.options = *consumerOpts
+	handler := behaviorHandler(behavior)
+	if consumerOpts.Router != nil {
+		handler = consumerOpts.Router.Route
+	}
+	consumerProcess.handler = chain(consumerOpts.Middleware, handler)
+	consumerProcess.pull.lastSequence.Store(consumerOpts.ResumeSequence)
+	if consumerOpts.BatchSize > 0 {
+		// one batch at a time
+		consumerProcess.credits = make(chan struct{}, 1)
+	} else if consumerOpts.MaxInFlight > 0 {
+		consumerProcess.credits = make(chan struct{}, consumerOpts.MaxInFlight)
+	}
 	process.State = consumerProcess
+	if consumerOpts.ConnectionEvents != nil {
+		consumerOpts.ConnectionEvents.Subscribe(process)
+	}
 
 	// Initialize the Nats consumer based on consumerOpts
-	go consumerProcess.startPulling()
+	consumerProcess.attach()
 
 	return nil
 }
 
+// setDefaults fills in the options left at their zero value
+func (opts *PullConsumerOptions) setDefaults() {
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	if opts.ShutdownTimeout <= 0 {
+		opts.ShutdownTimeout = defaultShutdownTimeout
+	}
+	if opts.BatchMaxWait <= 0 {
+		opts.BatchMaxWait = defaultBatchMaxWait
+	}
+	if opts.Heartbeat != nil && opts.Heartbeat.Fraction == 0 {
+		heartbeat := *opts.Heartbeat
+		heartbeat.Fraction = defaultHeartbeatFraction
+		opts.Heartbeat = &heartbeat
+	}
+}
+
 func (c *PullConsumer) HandleCall(
 	process *gen.ServerProcess,
 	from gen.ServerFrom,
 	message etf.Term) (etf.Term, gen.ServerStatus) {
 
+	p := process.State.(*PullConsumerProcess)
+
+	if reply, ok, err := p.control(message); ok {
+		if err != nil {
+			return err, gen.ServerStatusOK
+		}
+		return reply, gen.ServerStatusOK
+	}
+
+	if handler, ok := p.behavior.(PullConsumerCallHandler); ok {
+		return handler.HandlePullConsumerCall(p, from, message)
+	}
+
 	return etf.Atom("ok"), gen.ServerStatusOK
 }
 
@@ -95,6 +281,14 @@ func (c *PullConsumer) HandleDirect(
 	process *gen.ServerProcess,
 	ref etf.Ref, message interface{}) (interface{}, gen.DirectStatus) {
 
+	p := process.State.(*PullConsumerProcess)
+	if reply, ok, err := p.control(message); ok {
+		return reply, err
+	}
+	if handler, ok := p.behavior.(PullConsumerDirectHandler); ok {
+		return handler.HandlePullConsumerDirect(p, ref, message)
+	}
+
 	return nil, fmt.Errorf("unsupported request")
 }
 
@@ -102,20 +296,148 @@ func (c *PullConsumer) HandleCast(
 	process *gen.ServerProcess,
 	message etf.Term) gen.ServerStatus {
 
-	behavior := process.Behavior().(PullConsumerBehavior)
 	p := process.State.(*PullConsumerProcess)
-	err := behavior.HandleMessage(p, message.(jetstream.Msg))
-	if err != nil {
-		p.options.Logger.Error("Failed to handle cast", slog.Any("error", err))
-		// dispatch / log error
+
+	// only messages and batches hold a credit
+	switch m := message.(type) {
+	case jetstream.Msg:
+		defer p.releaseCredit()
+		p.handleMessage(m)
+	case []jetstream.Msg:
+		defer p.releaseCredit()
+		p.handleBatch(m)
 	}
 
 	return gen.ServerStatusOK
 }
 
+func (process *PullConsumerProcess) handleMessage(msg jetstream.Msg) {
+	process.pull.untrack(msg)
+	if !process.pull.begin() {
+		_ = msg.Nak()
+		return
+	}
+	defer process.pull.end()
+
+	info, ok := process.processing(msg)
+	if !ok || process.duplicate(msg, info) {
+		return
+	}
+
+	process.delivery = info
+	defer func() { process.delivery = nil }()
+
+	stop := process.heartbeat(msg)
+	defer stop()
+	err := process.protect([]jetstream.Msg{msg}, func() error {
+		return process.dispatch(msg, info)
+	})
+	if stop() {
+		err = ceilingExceeded(err)
+	}
+	process.record(msg, err)
+	process.persist(info, err)
+	process.settle(msg, err)
+}
+
+// processing parses msg's delivery info and records its stream sequence as
+// the last one processed. In ordered mode it returns false for messages at or
+// before that sequence, which can be redelivered when an ordered consumer is
+// recreated. The delivery info is nil for messages without metadata.
+func (process *PullConsumerProcess) processing(msg jetstream.Msg) (*DeliveryInfo, bool) {
+	info, err := NewDeliveryInfo(msg)
+	if err != nil {
+		return nil, true
+	}
+	seq := info.StreamSequence
+	if process.options.Ordered && seq <= process.LastSequence() {
+		return info, false
+	}
+	process.pull.advance(seq)
+	return info, true
+}
+
+// Delivery returns the delivery info of the message being handled, or nil
+// outside of a handler or for messages without JetStream metadata
+func (process *PullConsumerProcess) Delivery() *DeliveryInfo {
+	return process.delivery
+}
+
+// LastSequence returns the stream sequence of the last message handed to the
+// behavior, or the configured ResumeSequence if none has been
+func (process *PullConsumerProcess) LastSequence() uint64 {
+	return process.pull.lastSequence.Load()
+}
+
+// record updates the local counters, logs handler failures that
+// LoggingMiddleware did not already log and remembers them for dead-lettering
+func (process *PullConsumerProcess) record(msg jetstream.Msg, err error) {
+	if errors.Is(err, ErrInProgress) {
+		process.pull.handled.Add(1)
+		return
+	}
+	if process.options.DeadLetter != nil {
+		if meta, metaErr := msg.Metadata(); metaErr == nil {
+			// terminated or already settled messages are never dead-lettered
+			// by the advisory
+			failure := err
+			var term *TermError
+			var settled *settledError
+			if errors.As(err, &term) || errors.As(err, &settled) {
+				failure = nil
+			}
+			process.pull.recordFailure(meta.Sequence.Stream, failure)
+		}
+	}
+	logged := process.logged
+	process.logged = false
+	if err != nil {
+		process.pull.failed.Add(1)
+		if !logged {
+			process.options.Logger.Error("Failed to handle message",
+				slog.String("subject", msg.Subject()),
+				slog.Any("error", err),
+			)
+		}
+		return
+	}
+	process.pull.handled.Add(1)
+}
+
+// settle acknowledges msg based on the handler's error when AutoAck is enabled
+func (process *PullConsumerProcess) settle(msg jetstream.Msg, err error) {
+	if !process.options.AutoAck || process.options.Ordered {
+		return
+	}
+	if ackErr := settleMessage(msg, process.retry(msg, err)); ackErr != nil {
+		process.options.Logger.Error("Failed to acknowledge message",
+			slog.String("subject", msg.Subject()),
+			slog.Any("error", ackErr),
+		)
+	}
+}
+
 func (c *PullConsumer) HandleInfo(
 	process *gen.ServerProcess,
 	message etf.Term) gen.ServerStatus {
+
+	p := process.State.(*PullConsumerProcess)
+
+	switch m := message.(type) {
+	case messagePullAttached:
+		p.attempts = 0
+	case messagePullFailed:
+		return p.handlePullFailure(m.err)
+	case messagePullRetry:
+		p.attach()
+	case messageMaxDeliveries:
+		p.handleMaxDeliveries(m.advisory)
+	default:
+		if handler, ok := p.behavior.(PullConsumerInfoHandler); ok {
+			return handler.HandlePullConsumerInfo(p, message)
+		}
+	}
+
 	return gen.ServerStatusOK
 }
 
@@ -123,59 +445,329 @@ func (c *PullConsumer) Terminate(
 	process *gen.ServerProcess,
 	reason string) {
 
+	p, ok := process.State.(*PullConsumerProcess)
+	if !ok {
+		return
+	}
+	if p.options.ConnectionEvents != nil {
+		p.options.ConnectionEvents.Unsubscribe(process)
+	}
+	p.stopPulling(reason)
+}
+
+// attach starts pulling in the background. Any failure is sent back to the
+// process so that it can retry or stop.
+func (process *PullConsumerProcess) attach() {
+	if !process.pull.begin() {
+		return
+	}
+	go func() {
+		defer process.pull.end()
+		if err := process.startPulling(); err != nil {
+			_ = process.sendSelf(messagePullFailed{err: err})
+		}
+	}()
+}
+
+// handlePullFailure either schedules another attachment attempt or stops the
+// process with the failure as its reason. Configuration errors are never
+// retried.
+func (process *PullConsumerProcess) handlePullFailure(err error) gen.ServerStatus {
+	process.options.Logger.Error("Pull consumer failed",
+		slog.String("stream", process.options.StreamName),
+		slog.String("consumer", process.options.NatsConsumerConfig.Name),
+		slog.Any("error", err),
+	)
+
+	retry := process.options.AttachRetry
+	if retry == nil || retry.Exhausted(process.attempts) || permanent(err) {
+		return gen.ServerStatus(err)
+	}
+
+	delay := retry.Delay(process.attempts)
+	process.attempts++
+	process.options.Logger.Info("Retrying pull consumer attachment",
+		slog.Int("attempt", process.attempts),
+		slog.Duration("delay", delay),
+	)
+	process.SendAfter(process.Self(), messagePullRetry{}, delay)
+
+	return gen.ServerStatusOK
+}
+
+// permanent reports whether an attachment failure comes from the consumer's
+// configuration, which retrying cannot fix
+func permanent(err error) bool {
+	var optsErr *OptionsError
+	var drift *StreamDriftError
+	return errors.As(err, &optsErr) || errors.As(err, &drift)
 }
 
-func (process *PullConsumerProcess) startPulling() {
+func (process *PullConsumerProcess) startPulling() error {
 
 	//ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	ctx := context.Background()
 	streamName := process.options.StreamName
 	js, err := GetJetStream(process)
 	if err != nil {
-		process.options.Logger.Error("Failed to attach to JetStream",
-			slog.String("stream", streamName),
-			slog.Any("error", err),
-		)
-		return
+		return fmt.Errorf("failed to attach to JetStream: %w", err)
 	}
 
-	stream, err := js.Stream(ctx, streamName)
+	stream, err := process.options.attachStream(ctx, js)
 	if err != nil {
-		process.options.Logger.Error("Failed to attach to stream",
-			slog.String("stream", streamName),
-			slog.Any("error", err),
-		)
-		return
+		return fmt.Errorf("failed to attach to stream %s: %w", streamName, err)
+	}
+	if err := process.options.validateFilterSubjects(stream.CachedInfo().Config.Subjects); err != nil {
+		return err
+	}
+
+	if err := process.attachDedupe(ctx, js); err != nil {
+		return err
+	}
+
+	var cons jetstream.Consumer
+	if process.options.Ordered {
+		cons, err = stream.OrderedConsumer(ctx, process.orderedConfig())
+		if err != nil {
+			return fmt.Errorf("failed to create ordered consumer: %w", err)
+		}
+	} else if process.options.Bind {
+		cons, err = stream.Consumer(ctx, process.options.consumerName())
+		if err != nil {
+			return fmt.Errorf("failed to bind to consumer %s: %w",
+				process.options.consumerName(), err)
+		}
+	} else {
+		cons, err = stream.CreateOrUpdateConsumer(ctx, process.options.consumerConfig())
+		if err != nil {
+			return fmt.Errorf("failed to create or locate consumer %s: %w",
+				process.options.NatsConsumerConfig.Name, err)
+		}
 	}
 
-	cons, err := stream.CreateOrUpdateConsumer(ctx, process.options.NatsConsumerConfig)
+	if err := process.watchDeadLetters(ctx, js, cons); err != nil {
+		return err
+	}
+
+	if !process.pull.setConsumer(cons) {
+		return nil
+	}
+	return process.consume(cons)
+}
+
+// orderedConfig starts the ordered consumer right after the last processed
+// stream sequence, if there is one
+func (process *PullConsumerProcess) orderedConfig() jetstream.OrderedConsumerConfig {
+	cfg := process.options.OrderedConsumerConfig
+	if last := process.LastSequence(); last > 0 {
+		cfg.DeliverPolicy = jetstream.DeliverByStartSequencePolicy
+		cfg.OptStartSeq = last + 1
+		cfg.OptStartTime = nil
+	}
+	return cfg
+}
+
+// consume starts delivering messages from cons to the process
+func (process *PullConsumerProcess) consume(cons jetstream.Consumer) error {
+	if process.options.BatchSize > 0 {
+		return process.fetchBatches(cons)
+	}
+	if process.credits != nil {
+		return process.pullMessages(cons)
+	}
+
+	cc, err := cons.Consume(func(msg jetstream.Msg) {
+		process.deliver(msg)
+	}, jetstream.ConsumeErrHandler(process.handleConsumeError))
 	if err != nil {
-		process.options.Logger.Error("Failed to create or locate consumer",
+		return fmt.Errorf("failed to start consuming: %w", err)
+	}
+	process.pull.setSubscription(cc)
+	_ = process.sendSelf(messagePullAttached{})
+
+	return nil
+}
+
+// handleConsumeError logs transient consume errors and hands fatal ones back
+// to the process
+func (process *PullConsumerProcess) handleConsumeError(cc jetstream.ConsumeContext, err error) {
+	if !isFatalConsumeError(err) {
+		process.options.Logger.Warn("Consume error",
 			slog.String("consumer", process.options.NatsConsumerConfig.Name),
 			slog.Any("error", err),
 		)
 		return
 	}
+	cc.Stop()
+	_ = process.sendSelf(messagePullFailed{err: fmt.Errorf("consume failed: %w", err)})
+}
 
-	_, _ = cons.Consume(func(msg jetstream.Msg) {
-		process.Cast(process.Self(), msg)
-	})
+func isFatalConsumeError(err error) bool {
+	return errors.Is(err, jetstream.ErrConsumerDeleted) ||
+		errors.Is(err, jetstream.ErrConsumerNotFound) ||
+		errors.Is(err, jetstream.ErrBadRequest)
+}
 
+// deliver casts msg to the process, naking it if the process is stopping or
+// can no longer receive messages
+func (process *PullConsumerProcess) deliver(msg jetstream.Msg) bool {
+	if !process.pull.track(msg) {
+		_ = msg.Nak()
+		return false
+	}
+	if err := process.castSelf(msg); err != nil {
+		process.pull.untrack(msg)
+		_ = msg.Nak()
+		return false
+	}
+	process.pull.delivered.Add(1)
+	return true
 }
 
-func (opts PullConsumerOptions) validate() error {
-	return nil
+// sendSelf and castSelf send to the process from goroutines it does not own:
+// NATS callbacks and the pulling goroutines. Nothing is sent once the process
+// is stopping or its context is done, and Terminate waits for a send in
+// progress, since ergo must not be sent to while it tears the process down.
+func (process *PullConsumerProcess) sendSelf(message etf.Term) error {
+	return process.outside(func() error { return process.Send(process.Self(), message) })
+}
+
+func (process *PullConsumerProcess) castSelf(message etf.Term) error {
+	return process.outside(func() error { return process.Cast(process.Self(), message) })
+}
+
+func (process *PullConsumerProcess) outside(send func() error) error {
+	if !process.pull.begin() {
+		return errPullStopping
+	}
+	defer process.pull.end()
+	if err := process.Context().Err(); err != nil {
+		return err
+	}
+	return send()
+}
+
+// stopPulling drains the subscription, waits up to ShutdownTimeout for
+// in-flight handlers and naks every message that was never handled
+func (process *PullConsumerProcess) stopPulling(reason string) {
+	if sub := process.pull.stop(); sub != nil {
+		sub.Drain()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		process.pull.active.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(process.options.ShutdownTimeout):
+		process.options.Logger.Warn("Timed out waiting for in-flight handlers",
+			slog.String("consumer", process.options.NatsConsumerConfig.Name),
+			slog.Duration("timeout", process.options.ShutdownTimeout),
+		)
+	}
+
+	abandoned := process.pull.abandon()
+	for _, msg := range abandoned {
+		_ = msg.Nak()
+	}
+
+	process.options.Logger.Info("Pull consumer stopped",
+		slog.String("consumer", process.options.NatsConsumerConfig.Name),
+		slog.String("reason", reason),
+		slog.Int("abandoned", len(abandoned)),
+	)
+}
+
+// pullMessages only asks the iterator for the next message once the process
+// has a free credit, so no more than MaxInFlight messages are ever waiting in
+// the mailbox. The iterator buffers a single message and only pulls it from
+// JetStream while a credit is held, so nothing waits beyond the mailbox either.
+func (process *PullConsumerProcess) pullMessages(cons jetstream.Consumer) error {
+	// an iterator that was paused may still be draining, and the consumer
+	// does not support opening another one until it is done
+	done, previous := process.pull.handOver()
+	defer close(done)
+	if previous != nil {
+		select {
+		case <-previous:
+		case <-process.pull.quit:
+			return nil
+		}
+	}
+
+	iter, err := cons.Messages(jetstream.PullMaxMessages(1))
+	if err != nil {
+		return fmt.Errorf("failed to start message iterator: %w", err)
+	}
+	process.pull.setSubscription(iter)
+	_ = process.sendSelf(messagePullAttached{})
+
+	for {
+		select {
+		case process.credits <- struct{}{}:
+		case <-process.pull.quit:
+			process.nakRemaining(iter)
+			return nil
+		}
+		msg, err := iter.Next()
+		if err != nil {
+			process.releaseCredit()
+			if errors.Is(err, jetstream.ErrMsgIteratorClosed) {
+				return nil
+			}
+			if isFatalConsumeError(err) {
+				iter.Stop()
+				return fmt.Errorf("consume failed: %w", err)
+			}
+			process.options.Logger.Warn("Failed to pull message",
+				slog.String("consumer", process.options.NatsConsumerConfig.Name),
+				slog.Any("error", err),
+			)
+			continue
+		}
+		if !process.deliver(msg) {
+			process.releaseCredit()
+		}
+	}
+}
+
+// nakRemaining naks whatever is left in a draining iterator's buffer
+func (process *PullConsumerProcess) nakRemaining(iter jetstream.MessagesContext) {
+	for {
+		msg, err := iter.Next()
+		if err != nil {
+			return
+		}
+		_ = msg.Nak()
+	}
+}
+
+func (process *PullConsumerProcess) releaseCredit() {
+	if process.credits == nil {
+		return
+	}
+	select {
+	case <-process.credits:
+	default:
+	}
 }
 
 func GetJetStream(process *PullConsumerProcess) (jetstream.JetStream, error) {
+	return process.options.jetStream()
+}
+
+func (opts *PullConsumerOptions) jetStream() (jetstream.JetStream, error) {
 	var js jetstream.JetStream
 	var err error
 
-	domain := strings.TrimSpace(process.options.JsDomain)
+	domain := strings.TrimSpace(opts.JsDomain)
 	if len(domain) == 0 {
-		js, err = jetstream.New(process.options.Connection)
+		js, err = jetstream.New(opts.Connection)
 	} else {
-		js, err = jetstream.NewWithDomain(process.options.Connection, domain)
+		js, err = jetstream.NewWithDomain(opts.Connection, domain)
 	}
 
 	return js, err
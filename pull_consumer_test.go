@@ -0,0 +1,630 @@
+package ergonats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ergo-services/ergo"
+	"github.com/ergo-services/ergo/etf"
+	"github.com/ergo-services/ergo/gen"
+	"github.com/ergo-services/ergo/node"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+type testConsumer struct {
+	PullConsumer
+
+	opts    PullConsumerOptions
+	handled atomic.Int32
+}
+
+func (c *testConsumer) InitPullConsumer(
+	_ *PullConsumerProcess,
+	_ ...etf.Term) (*PullConsumerOptions, error) {
+
+	opts := c.opts
+	return &opts, nil
+}
+
+func (c *testConsumer) HandleMessage(_ *PullConsumerProcess, _ jetstream.Msg) error {
+	c.handled.Add(1)
+	return nil
+}
+
+func (c *testConsumer) HandlePullConsumerDirect(
+	process *PullConsumerProcess,
+	_ etf.Ref,
+	message interface{}) (interface{}, gen.DirectStatus) {
+
+	return fmt.Sprintf("%s:%v", process.Options().StreamName, message), gen.DirectStatusOK
+}
+
+func TestPullConsumerLifecycle(t *testing.T) {
+	shutdown, nc := startNatsServer(t)
+	defer shutdown()
+
+	js := createTestStream(t, nc, "LIFECYCLE", "lifecycle.>")
+	for i := 0; i < 10; i++ {
+		_, _ = js.Publish(context.Background(), fmt.Sprintf("lifecycle.%d", i), nil)
+	}
+
+	n := startTestNode(t)
+	defer n.Stop()
+
+	consumer := &testConsumer{
+		opts: PullConsumerOptions{
+			Connection: nc,
+			StreamName: "LIFECYCLE",
+			NatsConsumerConfig: jetstream.ConsumerConfig{
+				Durable: "lifecycle",
+			},
+			AutoAck:     true,
+			MaxInFlight: 2,
+			Middleware:  []Middleware{LoggingMiddleware(nil)},
+		},
+	}
+	p, err := n.Spawn("lifecycle", gen.ProcessOptions{}, consumer)
+	if err != nil {
+		t.Fatalf("failed to spawn consumer: %s", err)
+	}
+
+	waitFor(t, func() bool { return consumer.handled.Load() == 10 })
+
+	reply, err := p.Direct("ping")
+	if err != nil || reply != "LIFECYCLE:ping" {
+		t.Fatalf("direct request was not forwarded to the behavior: %v %v", reply, err)
+	}
+
+	caller, err := n.Spawn("lifecycle_caller", gen.ProcessOptions{}, &testCaller{})
+	if err != nil {
+		t.Fatalf("failed to spawn caller: %s", err)
+	}
+	call := func(message etf.Term) etf.Term {
+		reply, err := caller.Direct(testCall{to: p.Self(), message: message})
+		if err != nil {
+			t.Fatalf("call %T failed: %s", message, err)
+		}
+		return reply
+	}
+
+	if reply := call(MessagePullConsumerPause{}); reply != etf.Atom("ok") {
+		t.Fatalf("failed to pause: %v", reply)
+	}
+	_, _ = js.Publish(context.Background(), "lifecycle.paused", nil)
+	var status PullConsumerStatus
+	waitFor(t, func() bool {
+		status, _ = call(MessagePullConsumerStatus{}).(PullConsumerStatus)
+		return status.Info != nil && status.Info.NumPending == 1
+	})
+	if !status.Paused || status.Stats.Handled != 10 || consumer.handled.Load() != 10 {
+		t.Fatalf("unexpected status while paused: %+v", status)
+	}
+
+	// pause and resume again straight away, while the first iterator may
+	// still be draining
+	call(MessagePullConsumerResume{})
+	call(MessagePullConsumerPause{})
+	if reply := call(MessagePullConsumerResume{}); reply != etf.Atom("ok") {
+		t.Fatalf("failed to resume: %v", reply)
+	}
+	waitFor(t, func() bool { return consumer.handled.Load() == 11 })
+	status, _ = call(MessagePullConsumerStatus{}).(PullConsumerStatus)
+	if status.Paused || status.Stats.Handled != 11 {
+		t.Fatalf("unexpected status after resuming: %+v", status)
+	}
+
+	p.Kill()
+	if err := p.WaitWithTimeout(2 * time.Second); err != nil {
+		t.Fatalf("consumer did not stop: %s", err)
+	}
+
+	cons, err := js.Consumer(context.Background(), "LIFECYCLE", "lifecycle")
+	if err != nil {
+		t.Fatalf("failed to look up consumer: %s", err)
+	}
+	waitFor(t, func() bool {
+		info, err := cons.Info(context.Background())
+		return err == nil && info.NumAckPending == 0
+	})
+}
+
+type boundedConsumer struct {
+	testConsumer
+
+	process atomic.Pointer[PullConsumerProcess]
+}
+
+func (c *boundedConsumer) HandleMessage(process *PullConsumerProcess, _ jetstream.Msg) error {
+	c.process.Store(process)
+	time.Sleep(time.Millisecond)
+	c.handled.Add(1)
+	return nil
+}
+
+func TestPullConsumerBoundsInFlight(t *testing.T) {
+	shutdown, nc := startNatsServer(t)
+	defer shutdown()
+
+	js := createTestStream(t, nc, "BOUNDED", "bounded.>")
+	for i := 0; i < 100; i++ {
+		_, _ = js.Publish(context.Background(), "bounded.1", nil)
+	}
+
+	n := startTestNode(t)
+	defer n.Stop()
+
+	consumer := &boundedConsumer{testConsumer: testConsumer{opts: PullConsumerOptions{
+		Connection: nc,
+		StreamName: "BOUNDED",
+		NatsConsumerConfig: jetstream.ConsumerConfig{
+			Durable: "bounded",
+		},
+		AutoAck:     true,
+		MaxInFlight: 3,
+	}}}
+	p, err := n.Spawn("bounded", gen.ProcessOptions{}, consumer)
+	if err != nil {
+		t.Fatalf("failed to spawn consumer: %s", err)
+	}
+
+	var cons jetstream.Consumer
+	waitFor(t, func() bool {
+		cons, err = js.Consumer(context.Background(), "BOUNDED", "bounded")
+		return err == nil
+	})
+
+	deadline := time.Now().Add(10 * time.Second)
+	for consumer.handled.Load() < 100 {
+		if time.Now().After(deadline) {
+			t.Fatalf("handled %d of 100 messages", consumer.handled.Load())
+		}
+		if process := consumer.process.Load(); process != nil {
+			// casts other than messages hold no credit
+			_ = process.Cast(p.Self(), "noise")
+			process.pull.Lock()
+			queued := len(process.pull.inflight)
+			process.pull.Unlock()
+			if queued > 3 {
+				t.Fatalf("%d messages waiting in the mailbox, more than MaxInFlight", queued)
+			}
+		}
+		// nothing is buffered beyond the mailbox either
+		if info, err := cons.Info(context.Background()); err == nil && info.NumAckPending > 3 {
+			t.Fatalf("%d messages pending an ack, more than MaxInFlight", info.NumAckPending)
+		}
+		time.Sleep(100 * time.Microsecond)
+	}
+}
+
+// testCaller forwards each testCall it is sent with Direct as a Call, so that
+// tests can reach HandleCall
+type testCaller struct {
+	gen.Server
+}
+
+type testCall struct {
+	to      etf.Pid
+	message etf.Term
+}
+
+func (c *testCaller) HandleDirect(
+	process *gen.ServerProcess,
+	_ etf.Ref,
+	message interface{}) (interface{}, gen.DirectStatus) {
+
+	call := message.(testCall)
+	return process.Call(call.to, call.message)
+}
+
+func startNatsServer(t *testing.T) (func(), *nats.Conn) {
+	t.Helper()
+	opts := &server.Options{
+		JetStream: true,
+		Port:      -1,
+		StoreDir:  t.TempDir(),
+	}
+	s, err := server.NewServer(opts)
+	if err != nil {
+		t.Fatalf("failed to create nats-server: %s", err)
+	}
+	s.ConfigureLogger()
+	if err := server.Run(s); err != nil {
+		t.Fatalf("failed to start nats-server: %s", err)
+	}
+
+	go s.WaitForShutdown()
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		s.Shutdown()
+		t.Fatalf("failed to connect to nats-server: %s", err)
+	}
+	return s.Shutdown, nc
+}
+
+func createTestStream(t *testing.T, nc *nats.Conn, name string, subjects ...string) jetstream.JetStream {
+	t.Helper()
+	js, err := jetstream.New(nc)
+	if err != nil {
+		t.Fatalf("failed to get JetStream: %s", err)
+	}
+	_, err = js.CreateStream(context.Background(), jetstream.StreamConfig{
+		Name:     name,
+		Subjects: subjects,
+	})
+	if err != nil {
+		t.Fatalf("failed to create stream: %s", err)
+	}
+	return js
+}
+
+func startTestNode(t *testing.T) node.Node {
+	t.Helper()
+	n, err := ergo.StartNode(fmt.Sprintf("%s@localhost", t.Name()), "cookies", node.Options{})
+	if err != nil {
+		t.Fatalf("failed to start node: %s", err)
+	}
+	return n
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("condition not met before deadline")
+}
+
+func TestPullConsumerStopsWhenAttachFails(t *testing.T) {
+	shutdown, nc := startNatsServer(t)
+	defer shutdown()
+
+	n := startTestNode(t)
+	defer n.Stop()
+
+	consumer := &testConsumer{
+		opts: PullConsumerOptions{
+			Connection:  nc,
+			StreamName:  "MISSING",
+			AttachRetry: &Backoff{Initial: 10 * time.Millisecond, MaxRetries: 2},
+		},
+	}
+	p, err := n.Spawn("missing", gen.ProcessOptions{}, consumer)
+	if err != nil {
+		t.Fatalf("failed to spawn consumer: %s", err)
+	}
+
+	if err := p.WaitWithTimeout(5 * time.Second); err != nil {
+		t.Fatalf("consumer should have stopped after failing to attach: %s", err)
+	}
+}
+
+func TestPullConsumerStopsOnConfigErrorsAtAttach(t *testing.T) {
+	shutdown, nc := startNatsServer(t)
+	defer shutdown()
+
+	createTestStream(t, nc, "FILTERED", "filtered.>")
+
+	n := startTestNode(t)
+	defer n.Stop()
+
+	// retried forever if the error were treated as temporary
+	consumer := &testConsumer{
+		opts: PullConsumerOptions{
+			Connection: nc,
+			StreamName: "FILTERED",
+			NatsConsumerConfig: jetstream.ConsumerConfig{
+				Durable:       "filtered",
+				FilterSubject: "elsewhere.>",
+			},
+			AttachRetry: &Backoff{Initial: 10 * time.Millisecond},
+		},
+	}
+	p, err := n.Spawn("filtered", gen.ProcessOptions{}, consumer)
+	if err != nil {
+		t.Fatalf("failed to spawn consumer: %s", err)
+	}
+
+	if err := p.WaitWithTimeout(5 * time.Second); err != nil {
+		t.Fatalf("consumer should have stopped on an uncaptured filter subject: %s", err)
+	}
+}
+
+type testBatchConsumer struct {
+	testConsumer
+
+	batches atomic.Int32
+}
+
+func (c *testBatchConsumer) HandleBatch(_ *PullConsumerProcess, msgs []jetstream.Msg) error {
+	c.batches.Add(1)
+	c.handled.Add(int32(len(msgs)))
+
+	errs := make([]error, len(msgs))
+	for i, msg := range msgs {
+		if msg.Subject() == "batch.poison" {
+			errs[i] = Terminate(fmt.Errorf("poison"))
+		}
+	}
+	return &BatchError{Errors: errs}
+}
+
+func TestPullConsumerBatches(t *testing.T) {
+	shutdown, nc := startNatsServer(t)
+	defer shutdown()
+
+	js := createTestStream(t, nc, "BATCH", "batch.>")
+	for i := 0; i < 9; i++ {
+		_, _ = js.Publish(context.Background(), fmt.Sprintf("batch.%d", i), nil)
+	}
+	_, _ = js.Publish(context.Background(), "batch.poison", nil)
+
+	n := startTestNode(t)
+	defer n.Stop()
+
+	consumer := &testBatchConsumer{}
+	consumer.opts = PullConsumerOptions{
+		Connection: nc,
+		StreamName: "BATCH",
+		NatsConsumerConfig: jetstream.ConsumerConfig{
+			Durable: "batch",
+		},
+		AutoAck:      true,
+		BatchSize:    4,
+		BatchMaxWait: 100 * time.Millisecond,
+	}
+	p, err := n.Spawn("batch", gen.ProcessOptions{}, consumer)
+	if err != nil {
+		t.Fatalf("failed to spawn consumer: %s", err)
+	}
+
+	waitFor(t, func() bool { return consumer.handled.Load() == 10 })
+	if consumer.batches.Load() < 3 {
+		t.Fatalf("expected at least 3 batches, got %d", consumer.batches.Load())
+	}
+
+	// pause and resume while a fetch may still be waiting for messages
+	for _, control := range []interface{}{
+		MessagePullConsumerPause{},
+		MessagePullConsumerResume{},
+		MessagePullConsumerPause{},
+		MessagePullConsumerResume{},
+	} {
+		if _, err := p.Direct(control); err != nil {
+			t.Fatalf("%T failed: %s", control, err)
+		}
+	}
+	for i := 0; i < 4; i++ {
+		_, _ = js.Publish(context.Background(), fmt.Sprintf("batch.resumed.%d", i), nil)
+	}
+	waitFor(t, func() bool { return consumer.handled.Load() == 14 })
+
+	cons, _ := js.Consumer(context.Background(), "BATCH", "batch")
+	waitFor(t, func() bool {
+		info, err := cons.Info(context.Background())
+		return err == nil && info.NumAckPending == 0 && info.NumPending == 0
+	})
+	if handled := consumer.handled.Load(); handled != 14 {
+		t.Fatalf("handled %d messages, expected 14", handled)
+	}
+}
+
+func TestPullConsumerOrderedResume(t *testing.T) {
+	shutdown, nc := startNatsServer(t)
+	defer shutdown()
+
+	js := createTestStream(t, nc, "ORDERED", "ordered.>")
+	for i := 0; i < 10; i++ {
+		_, _ = js.Publish(context.Background(), fmt.Sprintf("ordered.%d", i), nil)
+	}
+
+	n := startTestNode(t)
+	defer n.Stop()
+
+	consumer := &testConsumer{
+		opts: PullConsumerOptions{
+			Connection:     nc,
+			StreamName:     "ORDERED",
+			Ordered:        true,
+			ResumeSequence: 4,
+		},
+	}
+	p, err := n.Spawn("ordered", gen.ProcessOptions{}, consumer)
+	if err != nil {
+		t.Fatalf("failed to spawn consumer: %s", err)
+	}
+
+	waitFor(t, func() bool { return consumer.handled.Load() == 6 })
+	reply, err := p.Direct(MessagePullConsumerStatus{})
+	if err != nil {
+		t.Fatalf("failed to get status: %s", err)
+	}
+	if last := reply.(PullConsumerStatus).LastSequence; last != 10 {
+		t.Fatalf("wrong last sequence: %d", last)
+	}
+}
+
+type failingConsumer struct {
+	testConsumer
+}
+
+func (c *failingConsumer) HandleMessage(_ *PullConsumerProcess, _ jetstream.Msg) error {
+	c.handled.Add(1)
+	return fmt.Errorf("downstream unavailable")
+}
+
+func TestPullConsumerDeadLetters(t *testing.T) {
+	shutdown, nc := startNatsServer(t)
+	defer shutdown()
+
+	js := createTestStream(t, nc, "ORDERS", "orders.>")
+
+	n := startTestNode(t)
+	defer n.Stop()
+
+	consumer := &failingConsumer{}
+	consumer.opts = PullConsumerOptions{
+		Connection: nc,
+		StreamName: "ORDERS",
+		NatsConsumerConfig: jetstream.ConsumerConfig{
+			Durable:    "orders",
+			MaxDeliver: 2,
+		},
+		AutoAck: true,
+		DeadLetter: &DeadLetterOptions{
+			Subject:    "dlq.orders",
+			StreamName: "ORDERS_DLQ",
+		},
+	}
+	p, err := n.Spawn("orders", gen.ProcessOptions{}, consumer)
+	if err != nil {
+		t.Fatalf("failed to spawn consumer: %s", err)
+	}
+	waitFor(t, func() bool {
+		_, err := js.Stream(context.Background(), "ORDERS_DLQ")
+		return err == nil
+	})
+
+	// replaying inside the duplicate window must not be deduplicated
+	_, _ = js.Publish(context.Background(), "orders.1.created", []byte("order"), jetstream.WithMsgID("order-1"))
+
+	dlq, _ := js.Stream(context.Background(), "ORDERS_DLQ")
+	var raw *jetstream.RawStreamMsg
+	waitFor(t, func() bool {
+		raw, err = dlq.GetMsg(context.Background(), 1)
+		return err == nil
+	})
+	if raw.Header.Get(HeaderDeadLetterSubject) != "orders.1.created" ||
+		raw.Header.Get(HeaderDeadLetterDeliveries) != "2" ||
+		raw.Header.Get(HeaderDeadLetterConsumer) != "orders" ||
+		raw.Header.Get(HeaderDeadLetterError) != "downstream unavailable" {
+		t.Fatalf("dead letter is missing failure metadata: %+v", raw.Header)
+	}
+	if raw.Header.Get(nats.MsgIdHdr) != "" {
+		t.Fatalf("dead letter kept the original message ID: %+v", raw.Header)
+	}
+
+	p.Kill()
+	_ = p.WaitWithTimeout(2 * time.Second)
+
+	replayed, err := ReplayDeadLetters(context.Background(), js, "ORDERS_DLQ")
+	if err != nil || replayed != 1 {
+		t.Fatalf("failed to replay dead letters: %d %v", replayed, err)
+	}
+	orders, _ := js.Stream(context.Background(), "ORDERS")
+	replay, err := orders.GetMsg(context.Background(), 2)
+	if err != nil || string(replay.Data) != "order" || replay.Header.Get(HeaderDeadLetterError) != "" {
+		t.Fatalf("dead letter was not replayed to its original subject: %+v %v", replay, err)
+	}
+}
+
+func TestPullConsumerRejectsInvalidOptions(t *testing.T) {
+	shutdown, nc := startNatsServer(t)
+	defer shutdown()
+
+	n := startTestNode(t)
+	defer n.Stop()
+
+	cases := map[string]PullConsumerOptions{
+		"Connection": {StreamName: "EVENTS"},
+		"StreamName": {Connection: nc, StreamName: "EVENTS.ALL"},
+		"NatsConsumerConfig": {Connection: nc, StreamName: "EVENTS", NatsConsumerConfig: jetstream.ConsumerConfig{
+			Durable: "one",
+			Name:    "two",
+		}},
+		"NatsConsumerConfig.AckPolicy": {Connection: nc, StreamName: "EVENTS", AutoAck: true, NatsConsumerConfig: jetstream.ConsumerConfig{
+			AckPolicy: jetstream.AckNonePolicy,
+		}},
+		"BatchSize": {Connection: nc, StreamName: "EVENTS", BatchSize: 10},
+		"Bind":      {Connection: nc, StreamName: "EVENTS", Bind: true},
+	}
+
+	for field, opts := range cases {
+		_, err := n.Spawn("", gen.ProcessOptions{}, &testConsumer{opts: opts})
+		var optsErr *OptionsError
+		if !errors.Is(err, ErrInvalidOptions) || !errors.As(err, &optsErr) || optsErr.Field != field {
+			t.Fatalf("expected invalid %s, got %v", field, err)
+		}
+	}
+}
+
+func TestPullConsumerProvisionsStream(t *testing.T) {
+	shutdown, nc := startNatsServer(t)
+	defer shutdown()
+
+	n := startTestNode(t)
+	defer n.Stop()
+
+	declared := &jetstream.StreamConfig{
+		Name:     "DECLARED",
+		Subjects: []string{"declared.>"},
+	}
+	consumer := &testConsumer{
+		opts: PullConsumerOptions{
+			Connection:   nc,
+			StreamConfig: declared,
+			AutoAck:      true,
+		},
+	}
+	if _, err := n.Spawn("declared", gen.ProcessOptions{}, consumer); err != nil {
+		t.Fatalf("failed to spawn consumer: %s", err)
+	}
+
+	js, _ := jetstream.New(nc)
+	waitFor(t, func() bool {
+		_, err := js.Publish(context.Background(), "declared.1", nil)
+		return err == nil
+	})
+	waitFor(t, func() bool { return consumer.handled.Load() >= 1 })
+
+	drifted := *declared
+	drifted.Subjects = []string{"declared.>", "other.>"}
+	strict := &testConsumer{
+		opts: PullConsumerOptions{
+			Connection:        nc,
+			StreamConfig:      &drifted,
+			StreamDriftPolicy: StreamDriftPolicyError,
+		},
+	}
+	p, err := n.Spawn("strict", gen.ProcessOptions{}, strict)
+	if err != nil {
+		t.Fatalf("failed to spawn consumer: %s", err)
+	}
+	if err := p.WaitWithTimeout(5 * time.Second); err != nil {
+		t.Fatalf("consumer should have stopped on stream drift: %s", err)
+	}
+}
+
+func TestStreamDrift(t *testing.T) {
+	declared := jetstream.StreamConfig{
+		Name:     "DRIFT",
+		Subjects: []string{"a.>", "b.>"},
+		MaxAge:   time.Hour,
+	}
+	actual := jetstream.StreamConfig{
+		Name:     "DRIFT",
+		Subjects: []string{"b.>", "a.>"},
+		MaxAge:   time.Hour,
+		MaxMsgs:  -1,
+		Replicas: 1,
+	}
+	if drift := streamDrift(declared, actual); len(drift) != 0 {
+		t.Fatalf("unexpected drift: %v", drift)
+	}
+
+	actual.MaxAge = time.Minute
+	actual.Storage = jetstream.MemoryStorage
+	drift := streamDrift(declared, actual)
+	if len(drift) != 2 || drift[0] != "Storage" || drift[1] != "MaxAge" {
+		t.Fatalf("wrong drift: %v", drift)
+	}
+}
@@ -0,0 +1,78 @@
+package ergonats
+
+import (
+	"context"
+	"errors"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// ErrNoMessageHandler is returned by the HandleMessage of ContextPullConsumer,
+// which is only reached when a behavior's message callback is missing
+var ErrNoMessageHandler = errors.New("consumer: behavior does not implement HandleMessage")
+
+// PullConsumerContextHandler is an optional extension of PullConsumerBehavior.
+// When implemented it is called instead of HandleMessage with a context that
+// expires with the message's ack deadline and is cancelled when the process
+// exits, including on a supervisor shutdown, or is killed. A process that
+// traps exits only sees an exit as a message once the running handler
+// returned, so its handler keeps running until the deadline. With Heartbeat set
+// the deadline is the heartbeat ceiling instead, and ordered consumers, which
+// have no ack deadline, get none.
+type PullConsumerContextHandler interface {
+	HandleMessageContext(ctx context.Context, process *PullConsumerProcess, msg jetstream.Msg) error
+}
+
+// ContextPullConsumer is embedded instead of PullConsumer by behaviors that
+// handle messages with HandleMessageContext or a Router rather than
+// HandleMessage. Init fails when such a behavior has neither.
+type ContextPullConsumer struct {
+	PullConsumer
+}
+
+// HandleMessage stands in for the callback that behaviors embedding
+// ContextPullConsumer leave out
+func (c *ContextPullConsumer) HandleMessage(_ *PullConsumerProcess, _ jetstream.Msg) error {
+	return ErrNoMessageHandler
+}
+
+func (c *ContextPullConsumer) withoutHandleMessage() {}
+
+// contextOnly is implemented by behaviors embedding ContextPullConsumer
+type contextOnly interface {
+	withoutHandleMessage()
+}
+
+// dispatch hands msg to the middleware chain ending in the behavior's message
+// callback, with its delivery info in the context
+func (process *PullConsumerProcess) dispatch(msg jetstream.Msg, info *DeliveryInfo) error {
+	ctx, cancel := process.handlerContext()
+	defer cancel()
+	return process.handler(withDeliveryInfo(ctx, info), process, msg)
+}
+
+// behaviorHandler adapts the behavior's message callback to a MessageHandler
+func behaviorHandler(behavior PullConsumerBehavior) MessageHandler {
+	if handler, ok := behavior.(PullConsumerContextHandler); ok {
+		return handler.HandleMessageContext
+	}
+	return func(_ context.Context, process *PullConsumerProcess, msg jetstream.Msg) error {
+		return behavior.HandleMessage(process, msg)
+	}
+}
+
+// handlerContext derives a handler's context from the process context, which
+// is cancelled when the process exits without trapping exits, or is killed
+func (process *PullConsumerProcess) handlerContext() (context.Context, context.CancelFunc) {
+	ctx := process.Context()
+	if process.options.Ordered {
+		return context.WithCancel(ctx)
+	}
+	if heartbeat := process.options.Heartbeat; heartbeat != nil {
+		if heartbeat.Ceiling > 0 {
+			return context.WithTimeout(ctx, heartbeat.Ceiling)
+		}
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, process.ackWait())
+}
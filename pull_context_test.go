@@ -0,0 +1,131 @@
+package ergonats
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ergo-services/ergo/etf"
+	"github.com/ergo-services/ergo/gen"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+type contextConsumer struct {
+	ContextPullConsumer
+
+	opts    PullConsumerOptions
+	started atomic.Int32
+
+	mu   sync.Mutex
+	errs []error
+}
+
+func (c *contextConsumer) InitPullConsumer(_ *PullConsumerProcess, _ ...etf.Term) (*PullConsumerOptions, error) {
+	return &c.opts, nil
+}
+
+func (c *contextConsumer) HandleMessageContext(ctx context.Context, _ *PullConsumerProcess, _ jetstream.Msg) error {
+	c.started.Add(1)
+	<-ctx.Done()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.errs = append(c.errs, ctx.Err())
+	return ctx.Err()
+}
+
+func (c *contextConsumer) results() []error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]error(nil), c.errs...)
+}
+
+func TestPullConsumerHandlerContext(t *testing.T) {
+	shutdown, nc := startNatsServer(t)
+	defer shutdown()
+
+	js := createTestStream(t, nc, "CTX", "ctx.>")
+	_, _ = js.Publish(context.Background(), "ctx.1", nil)
+
+	n := startTestNode(t)
+	defer n.Stop()
+
+	consumer := &contextConsumer{opts: PullConsumerOptions{
+		Connection: nc,
+		StreamName: "CTX",
+		NatsConsumerConfig: jetstream.ConsumerConfig{
+			Durable: "ctx",
+			AckWait: 200 * time.Millisecond,
+		},
+		AutoAck: true,
+	}}
+	p, err := n.Spawn("ctx", gen.ProcessOptions{}, consumer)
+	if err != nil {
+		t.Fatalf("failed to spawn consumer: %s", err)
+	}
+
+	// the handler times out with the ack deadline
+	waitFor(t, func() bool { return len(consumer.results()) >= 1 })
+	if err := consumer.results()[0]; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("handler context should expire with AckWait: %v", err)
+	}
+
+	// and is cancelled when the process terminates
+	count := len(consumer.results())
+	waitFor(t, func() bool { return int(consumer.started.Load()) > count })
+	p.Kill()
+	waitFor(t, func() bool { return len(consumer.results()) > count })
+	if err := consumer.results()[count]; !errors.Is(err, context.Canceled) {
+		t.Fatalf("handler context should be cancelled on termination: %v", err)
+	}
+
+	// a graceful exit, as on a supervisor shutdown, cancels it too
+	p, err = n.Spawn("ctx", gen.ProcessOptions{}, consumer)
+	if err != nil {
+		t.Fatalf("failed to respawn consumer: %s", err)
+	}
+	count = int(consumer.started.Load())
+	waitFor(t, func() bool { return int(consumer.started.Load()) > count })
+	if err := p.Exit("shutdown"); err != nil {
+		t.Fatalf("failed to exit: %s", err)
+	}
+	waitFor(t, func() bool { return len(consumer.results()) > count })
+	if err := consumer.results()[count]; !errors.Is(err, context.Canceled) {
+		t.Fatalf("handler context should be cancelled on a graceful exit: %v", err)
+	}
+}
+
+type handlerlessConsumer struct {
+	ContextPullConsumer
+
+	opts PullConsumerOptions
+}
+
+func (c *handlerlessConsumer) InitPullConsumer(_ *PullConsumerProcess, _ ...etf.Term) (*PullConsumerOptions, error) {
+	return &c.opts, nil
+}
+
+func TestPullConsumerRequiresMessageHandler(t *testing.T) {
+	shutdown, nc := startNatsServer(t)
+	defer shutdown()
+
+	n := startTestNode(t)
+	defer n.Stop()
+
+	opts := PullConsumerOptions{Connection: nc, StreamName: "CTX"}
+	_, err := n.Spawn("", gen.ProcessOptions{}, &handlerlessConsumer{opts: opts})
+	var optsErr *OptionsError
+	if !errors.As(err, &optsErr) || optsErr.Field != "HandleMessageContext" {
+		t.Fatalf("expected a missing handler to be rejected, got %v", err)
+	}
+
+	opts.Router = NewRouter()
+	p, err := n.Spawn("", gen.ProcessOptions{}, &handlerlessConsumer{opts: opts})
+	if err != nil {
+		t.Fatalf("a router should stand in for the handler: %s", err)
+	}
+	p.Kill()
+}
@@ -0,0 +1,138 @@
+package ergonats
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/ergo-services/ergo/etf"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	statusTimeout = 2 * time.Second
+)
+
+var ErrNotAttached = errors.New("pull consumer is not attached to JetStream")
+
+// MessagePullConsumerPause pauses pulling when sent to a pull consumer with
+// Call or Direct
+type MessagePullConsumerPause struct{}
+
+// MessagePullConsumerResume resumes pulling when sent to a pull consumer with
+// Call or Direct
+type MessagePullConsumerResume struct{}
+
+// MessagePullConsumerStatus asks a pull consumer for its PullConsumerStatus
+type MessagePullConsumerStatus struct{}
+
+// PullConsumerStats are the local counters kept by a pull consumer process
+type PullConsumerStats struct {
+	Delivered uint64
+	Handled   uint64
+	Failed    uint64
+}
+
+// PullConsumerStatus is the reply to MessagePullConsumerStatus
+type PullConsumerStatus struct {
+	Paused       bool
+	LastSequence uint64
+	Info         *jetstream.ConsumerInfo
+	Stats        PullConsumerStats
+}
+
+// Pause stops pulling new messages. Messages already delivered to the process
+// are still handled.
+func (process *PullConsumerProcess) Pause() error {
+	sub, ok := process.pull.pause()
+	if !ok {
+		return nil
+	}
+	if sub != nil {
+		sub.Drain()
+	}
+	process.options.Logger.Info("Pull consumer paused",
+		slog.String("consumer", process.options.NatsConsumerConfig.Name),
+	)
+	return nil
+}
+
+// Resume starts pulling again from the consumer created when the process
+// attached to JetStream
+func (process *PullConsumerProcess) Resume() error {
+	cons := process.pull.resume()
+	if cons != nil && process.options.Ordered {
+		// ordered consumers cannot be consumed twice, so recreate one that
+		// starts after the last processed sequence
+		process.attach()
+		return nil
+	}
+	if cons == nil {
+		// not paused, or never attached in which case a pending attachment
+		// starts pulling on its own
+		return nil
+	}
+	if !process.pull.begin() {
+		return nil
+	}
+	go func() {
+		defer process.pull.end()
+		if err := process.consume(cons); err != nil {
+			_ = process.sendSelf(messagePullFailed{err: err})
+		}
+	}()
+	process.options.Logger.Info("Pull consumer resumed",
+		slog.String("consumer", process.options.NatsConsumerConfig.Name),
+	)
+	return nil
+}
+
+// control answers the built-in control messages. It reports false for any
+// other message.
+func (process *PullConsumerProcess) control(message interface{}) (interface{}, bool, error) {
+	switch message.(type) {
+	case MessagePullConsumerPause:
+		return etf.Atom("ok"), true, process.Pause()
+	case MessagePullConsumerResume:
+		return etf.Atom("ok"), true, process.Resume()
+	case MessagePullConsumerStatus:
+		status, err := process.Status()
+		return status, true, err
+	}
+	return nil, false, nil
+}
+
+// Stats returns the local counters of the pull consumer
+func (process *PullConsumerProcess) Stats() PullConsumerStats {
+	return PullConsumerStats{
+		Delivered: process.pull.delivered.Load(),
+		Handled:   process.pull.handled.Load(),
+		Failed:    process.pull.failed.Load(),
+	}
+}
+
+// Status queries JetStream for the consumer's info and combines it with the
+// local counters
+func (process *PullConsumerProcess) Status() (PullConsumerStatus, error) {
+	status := PullConsumerStatus{
+		Paused:       process.pull.isPaused(),
+		LastSequence: process.LastSequence(),
+		Stats:        process.Stats(),
+	}
+
+	cons := process.pull.currentConsumer()
+	if cons == nil {
+		return status, ErrNotAttached
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), statusTimeout)
+	defer cancel()
+	info, err := cons.Info(ctx)
+	if err != nil {
+		return status, err
+	}
+	status.Info = info
+
+	return status, nil
+}
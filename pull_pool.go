@@ -0,0 +1,294 @@
+package ergonats
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/ergo-services/ergo/etf"
+	"github.com/ergo-services/ergo/gen"
+)
+
+const poolWorkerSpec = "worker"
+
+// MessagePullConsumerPoolResize asks a pool to grow or shrink to Size workers.
+// The reply is the new size.
+type MessagePullConsumerPoolResize struct {
+	Size int
+}
+
+// MessagePullConsumerPoolSize asks a pool how many workers are running
+type MessagePullConsumerPoolSize struct{}
+
+// messagePoolSync asks the pool manager to bring the worker count to its size
+type messagePoolSync struct{}
+
+// poolMember is appended to the arguments of every pool worker. It carries the
+// failure log the workers share, since the worker that receives a message's
+// max-deliveries advisory is rarely the one that recorded its last error.
+type poolMember struct {
+	failures *failureLog
+}
+
+// joinPool strips the poolMember from a worker's arguments, if any
+func joinPool(args []etf.Term) ([]etf.Term, *poolMember) {
+	if len(args) == 0 {
+		return args, nil
+	}
+	member, ok := args[len(args)-1].(poolMember)
+	if !ok {
+		return args, nil
+	}
+	return args[:len(args)-1], &member
+}
+
+// PullConsumerPoolOptions describes a pool of identical pull consumers sharing
+// one durable consumer. The embedded options provision the stream and the
+// consumer once; Worker should return the same options with Bind set from its
+// InitPullConsumer so that workers never update the consumer themselves.
+type PullConsumerPoolOptions struct {
+	PullConsumerOptions
+
+	// Name registers the pool manager, which answers resize and size
+	// requests, and prefixes the name of the workers' supervisor
+	Name   string
+	Size   int
+	Worker PullConsumerBehavior
+	Args   []etf.Term
+}
+
+// PullConsumerPoolWorkersName returns the registered name of the supervisor
+// that owns a pool's workers
+func PullConsumerPoolWorkersName(name string) string {
+	return fmt.Sprintf("%s_workers", name)
+}
+
+// PullConsumerPoolSpec creates the pool's stream and durable consumer and
+// returns a supervisor spec running its workers. Each worker is restarted on
+// its own when it crashes. Resize the pool by sending
+// MessagePullConsumerPoolResize to the process registered as opts.Name.
+func PullConsumerPoolSpec(opts PullConsumerPoolOptions) (gen.SupervisorSpec, error) {
+	if strings.TrimSpace(opts.Name) == "" {
+		return gen.SupervisorSpec{}, invalidOption("pool", "Name", "a name is required to register the pool")
+	}
+	if opts.Size < 0 {
+		return gen.SupervisorSpec{}, invalidOption("pool", "Size", "must not be negative")
+	}
+	if opts.Worker == nil {
+		return gen.SupervisorSpec{}, invalidOption("pool", "Worker", "a worker behavior is required")
+	}
+	if opts.Ordered {
+		return gen.SupervisorSpec{}, invalidOption("pool", "Ordered", "ordered consumers cannot be shared")
+	}
+	if err := opts.provision(); err != nil {
+		return gen.SupervisorSpec{}, err
+	}
+
+	workers := PullConsumerPoolWorkersName(opts.Name)
+	return gen.SupervisorSpec{
+		Name: opts.Name,
+		Children: []gen.SupervisorChildSpec{
+			{
+				Name: workers,
+				Child: &pullConsumerWorkers{
+					worker:   opts.Worker,
+					args:     opts.Args,
+					failures: newFailureLog(),
+				},
+			},
+			{
+				Name:  opts.Name,
+				Child: &pullConsumerPoolManager{},
+				Args:  []etf.Term{workers, opts.Size},
+			},
+		},
+		Strategy: gen.SupervisorStrategy{
+			// the manager follows its workers' supervisor on restart
+			Type:      gen.SupervisorStrategyRestForOne,
+			Intensity: gen.SupervisorRestartIntensity,
+			Period:    gen.SupervisorRestartPeriod,
+			Restart:   gen.SupervisorStrategyRestartTransient,
+		},
+	}, nil
+}
+
+// provision creates or updates the stream and the durable consumer shared by
+// the pool's workers
+func (opts *PullConsumerPoolOptions) provision() error {
+	if opts.StreamConfig != nil && opts.StreamName == "" {
+		opts.StreamName = opts.StreamConfig.Name
+	}
+	if err := opts.PullConsumerOptions.validate(opts.Worker); err != nil {
+		return err
+	}
+	if opts.consumerName() == "" {
+		return invalidOption("pool", "NatsConsumerConfig", "a pool requires a durable consumer name")
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+
+	ctx := context.Background()
+	js, err := opts.jetStream()
+	if err != nil {
+		return fmt.Errorf("failed to attach to JetStream: %w", err)
+	}
+	stream, err := opts.attachStream(ctx, js)
+	if err != nil {
+		return fmt.Errorf("failed to attach to stream %s: %w", opts.StreamName, err)
+	}
+	if err := opts.validateFilterSubjects(stream.CachedInfo().Config.Subjects); err != nil {
+		return err
+	}
+	if _, err := stream.CreateOrUpdateConsumer(ctx, opts.consumerConfig()); err != nil {
+		return fmt.Errorf("failed to create or update consumer %s: %w", opts.consumerName(), err)
+	}
+	return nil
+}
+
+// pullConsumerWorkers supervises the pool's workers, which are started on
+// demand by the pool manager
+type pullConsumerWorkers struct {
+	gen.Supervisor
+
+	worker   PullConsumerBehavior
+	args     []etf.Term
+	failures *failureLog
+}
+
+func (s *pullConsumerWorkers) Init(_ ...etf.Term) (gen.SupervisorSpec, error) {
+	args := append(append([]etf.Term(nil), s.args...), poolMember{failures: s.failures})
+	return gen.SupervisorSpec{
+		Children: []gen.SupervisorChildSpec{
+			{
+				Name:  poolWorkerSpec,
+				Child: s.worker,
+				Args:  args,
+			},
+		},
+		Strategy: gen.SupervisorStrategy{
+			Type:      gen.SupervisorStrategySimpleOneForOne,
+			Intensity: gen.SupervisorRestartIntensity,
+			Period:    gen.SupervisorRestartPeriod,
+			// workers retired by a resize exit with "shutdown"
+			Restart: gen.SupervisorStrategyRestartTransient,
+		},
+	}, nil
+}
+
+// pullConsumerPoolManager keeps the number of workers at the pool's size
+type pullConsumerPoolManager struct {
+	gen.Server
+}
+
+type pullConsumerPoolState struct {
+	workers string
+	size    int
+}
+
+func (m *pullConsumerPoolManager) Init(process *gen.ServerProcess, args ...etf.Term) error {
+	process.State = &pullConsumerPoolState{
+		workers: args[0].(string),
+		size:    args[1].(int),
+	}
+	// the workers' supervisor may still hold workers from before a restart
+	return process.Send(process.Self(), messagePoolSync{})
+}
+
+func (m *pullConsumerPoolManager) HandleCall(
+	process *gen.ServerProcess,
+	_ gen.ServerFrom,
+	message etf.Term) (etf.Term, gen.ServerStatus) {
+
+	reply, err := m.handleRequest(process, message)
+	if err != nil {
+		return err, gen.ServerStatusOK
+	}
+	return reply, gen.ServerStatusOK
+}
+
+func (m *pullConsumerPoolManager) HandleDirect(
+	process *gen.ServerProcess,
+	_ etf.Ref,
+	message interface{}) (interface{}, gen.DirectStatus) {
+
+	return m.handleRequest(process, message)
+}
+
+func (m *pullConsumerPoolManager) HandleInfo(
+	process *gen.ServerProcess,
+	message etf.Term) gen.ServerStatus {
+
+	if _, ok := message.(messagePoolSync); ok {
+		if _, err := m.sync(process); err != nil {
+			return gen.ServerStatus(err)
+		}
+	}
+	return gen.ServerStatusOK
+}
+
+func (m *pullConsumerPoolManager) handleRequest(process *gen.ServerProcess, message interface{}) (interface{}, error) {
+	state := process.State.(*pullConsumerPoolState)
+
+	switch r := message.(type) {
+	case MessagePullConsumerPoolResize:
+		if r.Size < 0 {
+			return nil, invalidOption("pool", "Size", "must not be negative")
+		}
+		state.size = r.Size
+		return m.sync(process)
+	case MessagePullConsumerPoolSize:
+		workers, err := m.running(process)
+		return len(workers), err
+	}
+	return nil, fmt.Errorf("unsupported request")
+}
+
+// sync starts or retires workers until the pool has its configured size
+func (m *pullConsumerPoolManager) sync(process *gen.ServerProcess) (int, error) {
+	state := process.State.(*pullConsumerPoolState)
+	supervisor := process.ProcessByName(state.workers)
+	if supervisor == nil {
+		return 0, fmt.Errorf("pool supervisor %s is not running", state.workers)
+	}
+
+	workers, err := m.running(process)
+	if err != nil {
+		return 0, err
+	}
+
+	for n := len(workers); n < state.size; n++ {
+		if _, err := (&gen.Supervisor{}).StartChild(supervisor, poolWorkerSpec); err != nil {
+			return n, fmt.Errorf("failed to start pool worker: %w", err)
+		}
+	}
+	for _, worker := range workers[min(state.size, len(workers)):] {
+		if err := worker.Exit("shutdown"); err != nil {
+			return state.size, err
+		}
+	}
+
+	return state.size, nil
+}
+
+// running returns the live workers
+func (m *pullConsumerPoolManager) running(process *gen.ServerProcess) ([]gen.Process, error) {
+	state := process.State.(*pullConsumerPoolState)
+	supervisor := process.ProcessByName(state.workers)
+	if supervisor == nil {
+		return nil, fmt.Errorf("pool supervisor %s is not running", state.workers)
+	}
+
+	pids, err := supervisor.Children()
+	if err != nil {
+		return nil, err
+	}
+	workers := make([]gen.Process, 0, len(pids))
+	for _, pid := range pids {
+		if worker := process.ProcessByPid(pid); worker != nil && worker.IsAlive() {
+			workers = append(workers, worker)
+		}
+	}
+	return workers, nil
+}
@@ -0,0 +1,193 @@
+package ergonats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/ergo-services/ergo/etf"
+	"github.com/ergo-services/ergo/gen"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+type testPool struct {
+	gen.Supervisor
+
+	opts PullConsumerPoolOptions
+}
+
+func (s *testPool) Init(_ ...etf.Term) (gen.SupervisorSpec, error) {
+	return PullConsumerPoolSpec(s.opts)
+}
+
+func TestPullConsumerPoolResizes(t *testing.T) {
+	shutdown, nc := startNatsServer(t)
+	defer shutdown()
+
+	js := createTestStream(t, nc, "JOBS", "jobs.>")
+	for i := 0; i < 20; i++ {
+		_, _ = js.Publish(context.Background(), fmt.Sprintf("jobs.%d", i), nil)
+	}
+
+	n := startTestNode(t)
+	defer n.Stop()
+
+	opts := PullConsumerOptions{
+		Connection: nc,
+		StreamName: "JOBS",
+		NatsConsumerConfig: jetstream.ConsumerConfig{
+			Durable: "jobs",
+		},
+		AutoAck: true,
+	}
+	worker := &testConsumer{opts: opts}
+	worker.opts.Bind = true
+
+	pool := &testPool{opts: PullConsumerPoolOptions{
+		PullConsumerOptions: opts,
+		Name:                "jobs",
+		Size:                2,
+		Worker:              worker,
+	}}
+	if _, err := n.Spawn("jobs_pool", gen.ProcessOptions{}, pool); err != nil {
+		t.Fatalf("failed to spawn pool: %s", err)
+	}
+
+	waitFor(t, func() bool { return worker.handled.Load() == 20 })
+
+	manager := n.ProcessByName("jobs")
+	size := func() int {
+		reply, err := manager.Direct(MessagePullConsumerPoolSize{})
+		if err != nil {
+			t.Fatalf("failed to get pool size: %s", err)
+		}
+		return reply.(int)
+	}
+	waitFor(t, func() bool { return size() == 2 })
+
+	if _, err := manager.Direct(MessagePullConsumerPoolResize{Size: 4}); err != nil {
+		t.Fatalf("failed to grow pool: %s", err)
+	}
+	waitFor(t, func() bool { return size() == 4 })
+
+	if _, err := manager.Direct(MessagePullConsumerPoolResize{Size: 1}); err != nil {
+		t.Fatalf("failed to shrink pool: %s", err)
+	}
+	waitFor(t, func() bool { return size() == 1 })
+
+	// a crashed worker is restarted on its own
+	workers, _ := n.ProcessByName(PullConsumerPoolWorkersName("jobs")).Children()
+	n.ProcessByPid(workers[0]).Kill()
+	waitFor(t, func() bool {
+		children, _ := n.ProcessByName(PullConsumerPoolWorkersName("jobs")).Children()
+		return len(children) == 1 && children[0] != workers[0]
+	})
+
+	_, _ = js.Publish(context.Background(), "jobs.after", nil)
+	waitFor(t, func() bool { return worker.handled.Load() == 21 })
+}
+
+func TestPullConsumerPoolOfTypedWorkers(t *testing.T) {
+	shutdown, nc := startNatsServer(t)
+	defer shutdown()
+
+	js := createTestStream(t, nc, "TYPEDJOBS", "typedjobs.>")
+	for i := 0; i < 200; i++ {
+		raw, _ := json.Marshal(orderPlaced{OrderID: fmt.Sprint(i), Amount: i})
+		_, _ = js.Publish(context.Background(), fmt.Sprintf("typedjobs.%d", i), raw)
+	}
+
+	n := startTestNode(t)
+	defer n.Stop()
+
+	opts := PullConsumerOptions{
+		Connection: nc,
+		StreamName: "TYPEDJOBS",
+		NatsConsumerConfig: jetstream.ConsumerConfig{
+			Durable: "typedjobs",
+		},
+		AutoAck: true,
+	}
+	// every worker is spawned from the same behavior value
+	worker := &orderConsumer{opts: TypedPullConsumerOptions[orderPlaced]{PullConsumerOptions: opts}}
+	worker.opts.Bind = true
+
+	pool := &testPool{opts: PullConsumerPoolOptions{
+		PullConsumerOptions: opts,
+		Name:                "typedjobs",
+		Size:                3,
+		Worker:              worker,
+	}}
+	if _, err := n.Spawn("typedjobs_pool", gen.ProcessOptions{}, pool); err != nil {
+		t.Fatalf("failed to spawn pool: %s", err)
+	}
+
+	// workers started while others are handling messages initialize the
+	// same behavior value concurrently
+	waitFor(t, func() bool { return worker.count() > 0 })
+	manager := n.ProcessByName("typedjobs")
+	if _, err := manager.Direct(MessagePullConsumerPoolResize{Size: 6}); err != nil {
+		t.Fatalf("failed to grow pool: %s", err)
+	}
+	waitFor(t, func() bool { return worker.count() == 200 })
+}
+
+func TestPullConsumerPoolDeadLetters(t *testing.T) {
+	shutdown, nc := startNatsServer(t)
+	defer shutdown()
+
+	js := createTestStream(t, nc, "CHARGES", "charges.>")
+
+	n := startTestNode(t)
+	defer n.Stop()
+
+	opts := PullConsumerOptions{
+		Connection: nc,
+		StreamName: "CHARGES",
+		NatsConsumerConfig: jetstream.ConsumerConfig{
+			Durable:    "charges",
+			MaxDeliver: 2,
+		},
+		AutoAck: true,
+		DeadLetter: &DeadLetterOptions{
+			Subject:    "dlq.charges",
+			StreamName: "CHARGES_DLQ",
+		},
+	}
+	worker := &failingConsumer{}
+	worker.opts = opts
+	worker.opts.Bind = true
+
+	pool := &testPool{opts: PullConsumerPoolOptions{
+		PullConsumerOptions: opts,
+		Name:                "charges",
+		Size:                3,
+		Worker:              worker,
+	}}
+	if _, err := n.Spawn("charges_pool", gen.ProcessOptions{}, pool); err != nil {
+		t.Fatalf("failed to spawn pool: %s", err)
+	}
+	waitFor(t, func() bool {
+		_, err := js.Stream(context.Background(), "CHARGES_DLQ")
+		return err == nil
+	})
+
+	for i := 0; i < 5; i++ {
+		_, _ = js.Publish(context.Background(), fmt.Sprintf("charges.%d", i), nil)
+	}
+
+	// whichever worker gets the advisory knows the error another one recorded
+	dlq, _ := js.Stream(context.Background(), "CHARGES_DLQ")
+	for seq := uint64(1); seq <= 5; seq++ {
+		var raw *jetstream.RawStreamMsg
+		waitFor(t, func() bool {
+			var err error
+			raw, err = dlq.GetMsg(context.Background(), seq)
+			return err == nil
+		})
+		if raw.Header.Get(HeaderDeadLetterError) != "downstream unavailable" {
+			t.Fatalf("dead letter %d lost its error: %+v", seq, raw.Header)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package ergonats
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// pullSubscription is satisfied by both jetstream.ConsumeContext and
+// jetstream.MessagesContext
+type pullSubscription interface {
+	Stop()
+	Drain()
+}
+
+// pullState is shared by every copy of a PullConsumerProcess and tracks the
+// live JetStream subscription along with the messages that have been cast to
+// the process but not yet handled
+type pullState struct {
+	sync.Mutex
+
+	consumer     jetstream.Consumer
+	subscription pullSubscription
+	paused       bool
+	stopping     bool
+	inflight     map[jetstream.Msg]struct{}
+	active       sync.WaitGroup
+	quit         chan struct{}
+	pulling      chan struct{}
+	advisories   *nats.Subscription
+	failures     *failureLog
+	dedupe       jetstream.KeyValue
+
+	delivered atomic.Uint64
+	handled   atomic.Uint64
+	failed    atomic.Uint64
+
+	lastSequence atomic.Uint64
+	persisted    atomic.Uint64
+}
+
+func newPullState() *pullState {
+	return &pullState{
+		inflight: make(map[jetstream.Msg]struct{}),
+		failures: newFailureLog(),
+		quit:     make(chan struct{}),
+	}
+}
+
+// begin registers a unit of work that shutdown waits for. It returns false
+// once the consumer is stopping.
+func (st *pullState) begin() bool {
+	st.Lock()
+	defer st.Unlock()
+	if st.stopping {
+		return false
+	}
+	st.active.Add(1)
+	return true
+}
+
+func (st *pullState) end() {
+	st.active.Done()
+}
+
+// setConsumer records the consumer handle and reports whether pulling should
+// start right away
+func (st *pullState) setConsumer(consumer jetstream.Consumer) bool {
+	st.Lock()
+	defer st.Unlock()
+	st.consumer = consumer
+	return !st.paused && !st.stopping
+}
+
+// pause detaches the live subscription so it can be drained. It returns false
+// if the consumer was already paused.
+func (st *pullState) pause() (pullSubscription, bool) {
+	st.Lock()
+	defer st.Unlock()
+	if st.paused {
+		return nil, false
+	}
+	st.paused = true
+	sub := st.subscription
+	st.subscription = nil
+	return sub, true
+}
+
+// resume clears the paused flag and returns the consumer handle to pull from.
+// The handle is nil if the consumer was not paused or never attached.
+func (st *pullState) resume() jetstream.Consumer {
+	st.Lock()
+	defer st.Unlock()
+	if !st.paused {
+		return nil
+	}
+	st.paused = false
+	return st.consumer
+}
+
+// handOver registers the calling goroutine as the one pulling from an
+// iterator or fetching batches. done must be closed once it stops; previous is closed once the
+// goroutine that pulled before it has stopped, or is nil if there was none.
+func (st *pullState) handOver() (done, previous chan struct{}) {
+	st.Lock()
+	defer st.Unlock()
+	done = make(chan struct{})
+	previous = st.pulling
+	st.pulling = done
+	return done, previous
+}
+
+func (st *pullState) isPaused() bool {
+	st.Lock()
+	defer st.Unlock()
+	return st.paused
+}
+
+func (st *pullState) currentConsumer() jetstream.Consumer {
+	st.Lock()
+	defer st.Unlock()
+	return st.consumer
+}
+
+// advance moves the last processed stream sequence forward to seq
+func (st *pullState) advance(seq uint64) {
+	for {
+		last := st.lastSequence.Load()
+		if seq <= last || st.lastSequence.CompareAndSwap(last, seq) {
+			return
+		}
+	}
+}
+
+// setDedupe records the dedupe bucket along with the last stream sequence
+// persisted in it
+func (st *pullState) setDedupe(kv jetstream.KeyValue, persisted uint64) {
+	st.Lock()
+	defer st.Unlock()
+	st.dedupe = kv
+	st.persisted.Store(persisted)
+}
+
+func (st *pullState) dedupeBucket() jetstream.KeyValue {
+	st.Lock()
+	defer st.Unlock()
+	return st.dedupe
+}
+
+// setAdvisories records the advisory subscription, replacing any previous one
+func (st *pullState) setAdvisories(sub *nats.Subscription) {
+	st.Lock()
+	defer st.Unlock()
+	if st.advisories != nil {
+		_ = st.advisories.Unsubscribe()
+	}
+	if st.stopping {
+		_ = sub.Unsubscribe()
+		return
+	}
+	st.advisories = sub
+}
+
+// failureLog remembers the last handler error for a stream sequence so it
+// can be attached to the message if it is dead-lettered. The workers of a
+// partitioned consumer share the log of its router, which dead-letters, and
+// the workers of a pool share one log.
+type failureLog struct {
+	sync.Mutex
+
+	failures map[uint64]string
+}
+
+func newFailureLog() *failureLog {
+	return &failureLog{failures: make(map[uint64]string)}
+}
+
+func (st *pullState) recordFailure(seq uint64, err error) {
+	log := st.failures
+	log.Lock()
+	defer log.Unlock()
+	if err == nil {
+		delete(log.failures, seq)
+		return
+	}
+	log.failures[seq] = err.Error()
+}
+
+func (st *pullState) takeFailure(seq uint64) string {
+	log := st.failures
+	log.Lock()
+	defer log.Unlock()
+	reason := log.failures[seq]
+	delete(log.failures, seq)
+	return reason
+}
+
+// setSubscription records the subscription so it can be drained later. If the
+// consumer is already stopping the subscription is stopped right away.
+func (st *pullState) setSubscription(sub pullSubscription) {
+	st.Lock()
+	defer st.Unlock()
+	if st.stopping || st.paused {
+		sub.Drain()
+		return
+	}
+	st.subscription = sub
+}
+
+// track marks msg as waiting in the mailbox. It returns false once the
+// consumer is stopping.
+func (st *pullState) track(msg jetstream.Msg) bool {
+	st.Lock()
+	defer st.Unlock()
+	if st.stopping {
+		return false
+	}
+	st.inflight[msg] = struct{}{}
+	return true
+}
+
+func (st *pullState) untrack(msg jetstream.Msg) {
+	st.Lock()
+	defer st.Unlock()
+	delete(st.inflight, msg)
+}
+
+// stop flags the consumer as stopping and returns the subscription that
+// should be drained
+func (st *pullState) stop() pullSubscription {
+	st.Lock()
+	defer st.Unlock()
+	if st.stopping {
+		return nil
+	}
+	st.stopping = true
+	close(st.quit)
+	if st.advisories != nil {
+		_ = st.advisories.Unsubscribe()
+		st.advisories = nil
+	}
+	sub := st.subscription
+	st.subscription = nil
+	return sub
+}
+
+// abandon returns every message that was never handled and stops tracking them
+func (st *pullState) abandon() []jetstream.Msg {
+	st.Lock()
+	defer st.Unlock()
+	msgs := make([]jetstream.Msg, 0, len(st.inflight))
+	for msg := range st.inflight {
+		msgs = append(msgs, msg)
+	}
+	st.inflight = make(map[jetstream.Msg]struct{})
+	return msgs
+}
@@ -0,0 +1,282 @@
+package ergonats
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// ErrInvalidOptions is matched by every OptionsError, whichever component
+// rejected its options
+var ErrInvalidOptions = errors.New("invalid options")
+
+// OptionsError describes why an option of a component, such as a pull
+// consumer or a subscriber, is invalid. It matches ErrInvalidOptions with
+// errors.Is.
+type OptionsError struct {
+	Component string
+	Field     string
+	Reason    string
+}
+
+func (e *OptionsError) Error() string {
+	return fmt.Sprintf("%s: invalid %s: %s", e.Component, e.Field, e.Reason)
+}
+
+func (e *OptionsError) Is(target error) bool {
+	return target == ErrInvalidOptions
+}
+
+// invalid reports an invalid PullConsumerOptions field
+func invalid(field, format string, args ...interface{}) error {
+	return invalidOption("consumer", field, format, args...)
+}
+
+// invalidOption reports an invalid option of the named component
+func invalidOption(component, field, format string, args ...interface{}) error {
+	return &OptionsError{Component: component, Field: field, Reason: fmt.Sprintf(format, args...)}
+}
+
+func (opts PullConsumerOptions) validate(behavior PullConsumerBehavior) error {
+	if opts.Connection == nil {
+		return invalid("Connection", "a NATS connection is required")
+	}
+	if opts.Connection.IsClosed() {
+		return invalid("Connection", "the NATS connection is closed")
+	}
+	// a reconnecting connection is acceptable when attachment is retried
+	if !opts.Connection.IsConnected() &&
+		!(opts.AttachRetry != nil && opts.Connection.Status() == nats.RECONNECTING) {
+		return invalid("Connection", "the NATS connection is not connected")
+	}
+
+	if !validName(opts.StreamName) {
+		return invalid("StreamName", "%q is not a legal stream name", opts.StreamName)
+	}
+
+	if opts.StreamConfig != nil {
+		if opts.StreamConfig.Name != opts.StreamName {
+			return invalid("StreamConfig.Name", "%q does not match StreamName %q", opts.StreamConfig.Name, opts.StreamName)
+		}
+		for _, subject := range opts.StreamConfig.Subjects {
+			if !validSubject(subject, true) {
+				return invalid("StreamConfig.Subjects", "%q is not a legal subject", subject)
+			}
+		}
+	}
+
+	if opts.MaxInFlight < 0 {
+		return invalid("MaxInFlight", "must not be negative")
+	}
+	if opts.BatchSize < 0 {
+		return invalid("BatchSize", "must not be negative")
+	}
+	if opts.BatchSize > 0 {
+		if opts.MaxInFlight > 0 {
+			return invalid("MaxInFlight", "cannot be combined with BatchSize")
+		}
+		if opts.Router != nil {
+			return invalid("Router", "cannot be combined with BatchSize")
+		}
+		if _, ok := behavior.(PullConsumerBatchHandler); !ok {
+			return invalid("BatchSize", "the behavior does not implement HandleBatch")
+		}
+	}
+
+	if _, ok := behavior.(contextOnly); ok && opts.BatchSize == 0 && opts.Router == nil {
+		if _, ok := behavior.(PullConsumerContextHandler); !ok {
+			return invalid("HandleMessageContext", "the behavior implements neither HandleMessage nor HandleMessageContext, and no Router is set")
+		}
+	}
+
+	if opts.Ordered {
+		if err := opts.validateOrdered(); err != nil {
+			return err
+		}
+	} else if err := opts.validateConsumerConfig(); err != nil {
+		return err
+	}
+
+	if opts.Heartbeat != nil {
+		if opts.Heartbeat.Fraction < 0 || opts.Heartbeat.Fraction >= 1 {
+			return invalid("Heartbeat.Fraction", "must be between 0 and 1")
+		}
+		if opts.Heartbeat.Ceiling < 0 {
+			return invalid("Heartbeat.Ceiling", "must not be negative")
+		}
+		if opts.Ordered {
+			return invalid("Heartbeat", "ordered consumers need no acknowledgements")
+		}
+		if opts.NatsConsumerConfig.AckPolicy == jetstream.AckNonePolicy {
+			return invalid("NatsConsumerConfig.AckPolicy", "heartbeats require acknowledgements")
+		}
+	}
+
+	if opts.RecoverPolicy == RecoverDeadLetter && opts.DeadLetter == nil {
+		return invalid("RecoverPolicy", "dead-lettering panicking messages requires DeadLetter")
+	}
+	if opts.RecoverDelay < 0 {
+		return invalid("RecoverDelay", "must not be negative")
+	}
+
+	if opts.Dedupe != nil {
+		if !validName(opts.Dedupe.Bucket) {
+			return invalid("Dedupe.Bucket", "%q is not a legal bucket name", opts.Dedupe.Bucket)
+		}
+		if opts.Dedupe.Key != "" && !validSubject(opts.Dedupe.Key, false) {
+			return invalid("Dedupe.Key", "%q is not a legal key", opts.Dedupe.Key)
+		}
+		if opts.BatchSize > 0 {
+			return invalid("Dedupe", "cannot be combined with BatchSize")
+		}
+		if !opts.Ordered && opts.NatsConsumerConfig.MaxAckPending != 1 {
+			return invalid("Dedupe", "requires an ordered consumer or NatsConsumerConfig.MaxAckPending of 1")
+		}
+	}
+
+	if opts.Retry != nil {
+		if err := opts.validateRetry(); err != nil {
+			return err
+		}
+	}
+
+	if opts.DeadLetter != nil {
+		if !validSubject(opts.DeadLetter.Subject, false) {
+			return invalid("DeadLetter.Subject", "%q is not a legal subject", opts.DeadLetter.Subject)
+		}
+		if opts.DeadLetter.StreamName != "" && !validName(opts.DeadLetter.StreamName) {
+			return invalid("DeadLetter.StreamName", "%q is not a legal stream name", opts.DeadLetter.StreamName)
+		}
+		if opts.Ordered {
+			return invalid("DeadLetter", "ordered consumers never redeliver, so nothing can be dead-lettered")
+		}
+		if opts.NatsConsumerConfig.MaxDeliver <= 0 {
+			return invalid("DeadLetter", "requires NatsConsumerConfig.MaxDeliver to be set")
+		}
+	}
+
+	return nil
+}
+
+func (opts PullConsumerOptions) validateConsumerConfig() error {
+	cfg := opts.NatsConsumerConfig
+
+	if cfg.Durable != "" && !validName(cfg.Durable) {
+		return invalid("NatsConsumerConfig.Durable", "%q is not a legal consumer name", cfg.Durable)
+	}
+	if cfg.Name != "" && !validName(cfg.Name) {
+		return invalid("NatsConsumerConfig.Name", "%q is not a legal consumer name", cfg.Name)
+	}
+	if cfg.Durable != "" && cfg.Name != "" && cfg.Durable != cfg.Name {
+		return invalid("NatsConsumerConfig", "Durable %q and Name %q must match", cfg.Durable, cfg.Name)
+	}
+
+	if opts.Bind && cfg.Durable == "" && cfg.Name == "" {
+		return invalid("Bind", "binding requires a consumer name in NatsConsumerConfig")
+	}
+
+	if cfg.FilterSubject != "" && len(cfg.FilterSubjects) > 0 {
+		return invalid("NatsConsumerConfig", "FilterSubject and FilterSubjects cannot both be set")
+	}
+	for _, subject := range consumerFilterSubjects(cfg) {
+		if !validSubject(subject, true) {
+			return invalid("NatsConsumerConfig.FilterSubjects", "%q is not a legal subject", subject)
+		}
+	}
+
+	if cfg.AckPolicy == jetstream.AckNonePolicy {
+		if opts.AutoAck {
+			return invalid("NatsConsumerConfig.AckPolicy", "AutoAck requires an ack policy other than AckNone")
+		}
+		if opts.DeadLetter != nil {
+			return invalid("NatsConsumerConfig.AckPolicy", "dead-lettering requires acknowledgements")
+		}
+	}
+
+	return nil
+}
+
+func (opts PullConsumerOptions) validateRetry() error {
+	retry := opts.Retry
+	if len(retry.Delays) == 0 {
+		return invalid("Retry.Delays", "at least one delay is required")
+	}
+	for _, delay := range retry.Delays {
+		if delay < 0 {
+			return invalid("Retry.Delays", "delays must not be negative")
+		}
+	}
+	if !opts.AutoAck {
+		return invalid("Retry", "requires AutoAck")
+	}
+	if opts.Ordered {
+		return invalid("Retry", "ordered consumers never redeliver")
+	}
+	if retry.ServerBackOff {
+		for _, delay := range retry.Delays {
+			if delay == 0 {
+				return invalid("Retry.Delays", "server backoff delays must be positive")
+			}
+		}
+		if maxDeliver := opts.NatsConsumerConfig.MaxDeliver; maxDeliver > 0 && maxDeliver <= len(retry.Delays) {
+			return invalid("Retry", "server backoff requires MaxDeliver to exceed the number of delays")
+		}
+		if opts.NatsConsumerConfig.BackOff != nil {
+			return invalid("NatsConsumerConfig.BackOff", "cannot be combined with a server backoff retry policy")
+		}
+	}
+	return nil
+}
+
+func (opts PullConsumerOptions) validateOrdered() error {
+	cfg := opts.NatsConsumerConfig
+	if cfg.Durable != "" || cfg.Name != "" {
+		return invalid("NatsConsumerConfig", "ordered consumers are ephemeral and cannot be named")
+	}
+	if opts.Bind {
+		return invalid("Bind", "ordered consumers are created on attach and cannot be bound")
+	}
+	for _, subject := range opts.OrderedConsumerConfig.FilterSubjects {
+		if !validSubject(subject, true) {
+			return invalid("OrderedConsumerConfig.FilterSubjects", "%q is not a legal subject", subject)
+		}
+	}
+	return nil
+}
+
+// validateFilterSubjects checks that the consumer only filters on subjects
+// the stream actually captures
+func (opts PullConsumerOptions) validateFilterSubjects(streamSubjects []string) error {
+	if len(streamSubjects) == 0 {
+		// sourced and mirrored streams have no subjects of their own
+		return nil
+	}
+
+	filters := opts.OrderedConsumerConfig.FilterSubjects
+	if !opts.Ordered {
+		filters = consumerFilterSubjects(opts.NatsConsumerConfig)
+	}
+	for _, subject := range filters {
+		if !subjectCovered(subject, streamSubjects) {
+			return invalid("filter subject", "%q is not captured by stream %s", subject, opts.StreamName)
+		}
+	}
+	return nil
+}
+
+// consumerName returns the name of the durable consumer
+func (opts PullConsumerOptions) consumerName() string {
+	if opts.NatsConsumerConfig.Durable != "" {
+		return opts.NatsConsumerConfig.Durable
+	}
+	return opts.NatsConsumerConfig.Name
+}
+
+func consumerFilterSubjects(cfg jetstream.ConsumerConfig) []string {
+	if cfg.FilterSubject != "" {
+		return []string{cfg.FilterSubject}
+	}
+	return cfg.FilterSubjects
+}
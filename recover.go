@@ -0,0 +1,101 @@
+package ergonats
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// RecoverPolicy decides what happens to a message whose handler panics
+type RecoverPolicy int
+
+const (
+	// RecoverCrash lets the panic stop the process. The message is
+	// redelivered once its ack deadline passes.
+	RecoverCrash RecoverPolicy = iota
+	// RecoverNak naks the message with RecoverDelay and keeps the process
+	// running
+	RecoverNak
+	// RecoverTerm terminates the message so it is never redelivered
+	RecoverTerm
+	// RecoverDeadLetter republishes the message to the consumer's dead-letter
+	// subject and terminates it
+	RecoverDeadLetter
+)
+
+// PanicError is reported for a message whose handler panicked
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("handler panicked: %v", e.Value)
+}
+
+// protect runs handle for msgs, recovering from a panic according to the
+// RecoverPolicy. Recovered messages are settled here, whatever AutoAck says.
+func (process *PullConsumerProcess) protect(msgs []jetstream.Msg, handle func() error) (err error) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		panicErr := &PanicError{Value: r, Stack: debug.Stack()}
+		for _, msg := range msgs {
+			process.logPanic(msg, panicErr)
+		}
+		if process.options.RecoverPolicy == RecoverCrash {
+			panic(r)
+		}
+		for _, msg := range msgs {
+			process.recovered(msg, panicErr)
+		}
+		err = alreadySettled(panicErr)
+	}()
+
+	return handle()
+}
+
+func (process *PullConsumerProcess) logPanic(msg jetstream.Msg, panicErr *PanicError) {
+	attrs := []any{
+		slog.Any("panic", panicErr.Value),
+		slog.String("subject", msg.Subject()),
+		slog.String("stack", string(panicErr.Stack)),
+	}
+	if meta, err := msg.Metadata(); err == nil {
+		attrs = append(attrs,
+			slog.Uint64("sequence", meta.Sequence.Stream),
+			slog.Uint64("deliveries", meta.NumDelivered),
+		)
+	}
+	process.options.Logger.Error("Message handler panicked", attrs...)
+}
+
+// recovered settles a message whose handler panicked
+func (process *PullConsumerProcess) recovered(msg jetstream.Msg, panicErr *PanicError) {
+	if process.options.Ordered {
+		return
+	}
+
+	var err error
+	switch process.options.RecoverPolicy {
+	case RecoverNak:
+		err = msg.NakWithDelay(process.options.RecoverDelay)
+	case RecoverTerm:
+		err = msg.TermWithReason(panicErr.Error())
+	case RecoverDeadLetter:
+		if err = process.DeadLetter(msg, panicErr); err != nil {
+			process.options.Logger.Error("Failed to dead-letter panicking message", slog.Any("error", err))
+			err = msg.Nak()
+		}
+	}
+	if err != nil {
+		process.options.Logger.Error("Failed to settle panicking message",
+			slog.String("subject", msg.Subject()),
+			slog.Any("error", err),
+		)
+	}
+}
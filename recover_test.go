@@ -0,0 +1,93 @@
+package ergonats
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/ergo-services/ergo/gen"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestProtectSettlesByPolicy(t *testing.T) {
+	cases := map[RecoverPolicy]string{
+		RecoverNak:  "nak",
+		RecoverTerm: "term",
+	}
+	for policy, settled := range cases {
+		process := &PullConsumerProcess{
+			options: PullConsumerOptions{
+				Logger:        slog.Default(),
+				RecoverPolicy: policy,
+				RecoverDelay:  time.Second,
+			},
+		}
+		msg := &fakeMsg{subject: "orders.1"}
+		err := process.protect([]jetstream.Msg{msg}, func() error {
+			panic("boom")
+		})
+
+		var panicErr *PanicError
+		if !errors.As(err, &panicErr) || panicErr.Value != "boom" || len(panicErr.Stack) == 0 {
+			t.Fatalf("panic was not reported: %v", err)
+		}
+		if msg.settled != settled {
+			t.Fatalf("policy %d settled the message with %q", policy, msg.settled)
+		}
+		// the framework must not settle it again
+		if err := settleMessage(msg, err); err != nil || msg.settled != settled {
+			t.Fatalf("recovered message was settled twice")
+		}
+	}
+}
+
+type panickingConsumer struct {
+	testConsumer
+}
+
+func (c *panickingConsumer) HandleMessage(_ *PullConsumerProcess, msg jetstream.Msg) error {
+	if msg.Subject() == "panic.poison" {
+		panic("poison")
+	}
+	c.handled.Add(1)
+	return nil
+}
+
+func TestPullConsumerRecoversFromPanics(t *testing.T) {
+	shutdown, nc := startNatsServer(t)
+	defer shutdown()
+
+	js := createTestStream(t, nc, "PANIC", "panic.>")
+	_, _ = js.Publish(context.Background(), "panic.poison", nil)
+	_, _ = js.Publish(context.Background(), "panic.ok", nil)
+
+	n := startTestNode(t)
+	defer n.Stop()
+
+	consumer := &panickingConsumer{}
+	consumer.opts = PullConsumerOptions{
+		Connection: nc,
+		StreamName: "PANIC",
+		NatsConsumerConfig: jetstream.ConsumerConfig{
+			Durable: "panic",
+		},
+		AutoAck:       true,
+		RecoverPolicy: RecoverTerm,
+	}
+	p, err := n.Spawn("panic", gen.ProcessOptions{}, consumer)
+	if err != nil {
+		t.Fatalf("failed to spawn consumer: %s", err)
+	}
+
+	waitFor(t, func() bool { return consumer.handled.Load() == 1 })
+	cons, _ := js.Consumer(context.Background(), "PANIC", "panic")
+	waitFor(t, func() bool {
+		info, err := cons.Info(context.Background())
+		return err == nil && info.NumAckPending == 0 && info.NumPending == 0
+	})
+	if !p.IsAlive() {
+		t.Fatalf("consumer should have survived the panic")
+	}
+}
@@ -0,0 +1,90 @@
+package ergonats
+
+import (
+	"errors"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// RetryPolicy schedules the redelivery of messages whose handler failed,
+// based on how many times they were delivered. It requires AutoAck.
+type RetryPolicy struct {
+	// Delays is the redelivery schedule: the first failure waits Delays[0],
+	// the second Delays[1] and so on, the last delay repeating
+	Delays []time.Duration
+
+	// ServerBackOff configures Delays as the consumer's BackOff and leaves
+	// failed messages unacknowledged, letting JetStream apply the schedule,
+	// instead of naking them with a delay
+	ServerBackOff bool
+
+	// NoRetry reports failures that retrying cannot fix. Such messages are
+	// terminated right away.
+	NoRetry func(err error) bool
+}
+
+// NoRetryOn returns a NoRetry predicate matching any of targets with errors.Is
+func NoRetryOn(targets ...error) func(err error) bool {
+	return func(err error) bool {
+		for _, target := range targets {
+			if errors.Is(err, target) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Delay returns the redelivery delay after the given delivery, counted from 1
+func (p RetryPolicy) Delay(delivered uint64) time.Duration {
+	if len(p.Delays) == 0 {
+		return 0
+	}
+	i := int(min(delivered, uint64(len(p.Delays)))) - 1
+	return p.Delays[max(i, 0)]
+}
+
+// retry applies the RetryPolicy to a handler's error before it is settled
+func (process *PullConsumerProcess) retry(msg jetstream.Msg, err error) error {
+	policy := process.options.Retry
+	if policy == nil || !retryable(err) {
+		return err
+	}
+	if policy.NoRetry != nil && policy.NoRetry(err) {
+		return Terminate(err)
+	}
+	if policy.ServerBackOff {
+		// left unacknowledged for JetStream to redeliver on its schedule
+		return alreadySettled(err)
+	}
+
+	var delivered uint64 = 1
+	if meta, metaErr := msg.Metadata(); metaErr == nil {
+		delivered = meta.NumDelivered
+	}
+	return RetryAfter(policy.Delay(delivered), err)
+}
+
+// retryable reports whether err is a plain failure that the framework would
+// nak, as opposed to a success or an explicit settlement
+func retryable(err error) bool {
+	var term *TermError
+	var retry *RetryError
+	var settled *settledError
+	return err != nil &&
+		!errors.Is(err, ErrInProgress) &&
+		!errors.As(err, &term) &&
+		!errors.As(err, &retry) &&
+		!errors.As(err, &settled)
+}
+
+// consumerConfig returns the durable consumer's config, with the retry
+// schedule as its BackOff when the server applies it
+func (opts PullConsumerOptions) consumerConfig() jetstream.ConsumerConfig {
+	cfg := opts.NatsConsumerConfig
+	if opts.Retry != nil && opts.Retry.ServerBackOff {
+		cfg.BackOff = opts.Retry.Delays
+	}
+	return cfg
+}
@@ -0,0 +1,54 @@
+package ergonats
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	invalidOrder := errors.New("invalid order")
+	policy := &RetryPolicy{
+		Delays:  []time.Duration{time.Second, 5 * time.Second, 30 * time.Second},
+		NoRetry: NoRetryOn(invalidOrder),
+	}
+	process := &PullConsumerProcess{options: PullConsumerOptions{AutoAck: true, Retry: policy}}
+
+	for delivered, delay := range map[uint64]time.Duration{
+		1: time.Second,
+		2: 5 * time.Second,
+		3: 30 * time.Second,
+		9: 30 * time.Second,
+	} {
+		msg := &fakeMsg{metadata: &jetstream.MsgMetadata{NumDelivered: delivered}}
+		process.settle(msg, errors.New("downstream unavailable"))
+		if msg.settled != "nak" || msg.delay != delay {
+			t.Fatalf("delivery %d: settled %q after %s", delivered, msg.settled, msg.delay)
+		}
+	}
+
+	msg := &fakeMsg{metadata: &jetstream.MsgMetadata{NumDelivered: 1}}
+	process.settle(msg, invalidOrder)
+	if msg.settled != "term" {
+		t.Fatalf("non-retryable failure was %q", msg.settled)
+	}
+
+	// explicit settlements are left alone
+	msg = &fakeMsg{metadata: &jetstream.MsgMetadata{NumDelivered: 1}}
+	process.settle(msg, RetryAfter(time.Minute, nil))
+	if msg.delay != time.Minute {
+		t.Fatalf("explicit retry delay was overridden: %s", msg.delay)
+	}
+
+	policy.ServerBackOff = true
+	msg = &fakeMsg{metadata: &jetstream.MsgMetadata{NumDelivered: 1}}
+	process.settle(msg, errors.New("downstream unavailable"))
+	if msg.settled != "" {
+		t.Fatalf("server backoff should leave the message unacknowledged, got %q", msg.settled)
+	}
+	if cfg := process.options.consumerConfig(); len(cfg.BackOff) != 3 {
+		t.Fatalf("server backoff was not configured: %v", cfg.BackOff)
+	}
+}
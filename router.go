@@ -0,0 +1,115 @@
+package ergonats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// ErrNoRoute is reported for a message that matches no route when the router
+// has no fallback. Such messages are terminated.
+var ErrNoRoute = errors.New("no route matches subject")
+
+// SubjectParams holds the subject tokens captured by a route's named tokens
+type SubjectParams map[string]string
+
+// RouteHandler handles a message matched by a route
+type RouteHandler func(ctx context.Context, process *PullConsumerProcess, msg jetstream.Msg, params SubjectParams) error
+
+// Router dispatches messages to handlers registered against subject patterns.
+// Patterns may use the `*` and `>` wildcards and capture a single token with
+// `{name}`, as in `orders.{id}.created`. Routes are tried in registration
+// order. Set it as PullConsumerOptions.Router, or call Route from a handler.
+type Router struct {
+	routes   []route
+	fallback MessageHandler
+}
+
+type route struct {
+	tokens  []string
+	handler RouteHandler
+}
+
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// Handle registers handler for the subjects matched by pattern
+func (r *Router) Handle(pattern string, handler RouteHandler) error {
+	tokens := strings.Split(pattern, ".")
+	wildcards := make([]string, len(tokens))
+	names := make(map[string]bool)
+	for i, token := range tokens {
+		wildcards[i] = token
+		if name, ok := captureName(token); ok {
+			if name == "" || strings.ContainsAny(name, "{}*>") {
+				return fmt.Errorf("route %q: %q is not a legal capture", pattern, token)
+			}
+			if names[name] {
+				return fmt.Errorf("route %q: %q is captured twice", pattern, name)
+			}
+			names[name] = true
+			wildcards[i] = "*"
+		}
+	}
+	if !validSubject(strings.Join(wildcards, "."), true) {
+		return fmt.Errorf("route %q: not a legal subject pattern", pattern)
+	}
+
+	r.routes = append(r.routes, route{tokens: tokens, handler: handler})
+	return nil
+}
+
+// Fallback sets the handler for messages that match no route
+func (r *Router) Fallback(handler MessageHandler) {
+	r.fallback = handler
+}
+
+// Route hands msg to the first route matching its subject
+func (r *Router) Route(ctx context.Context, process *PullConsumerProcess, msg jetstream.Msg) error {
+	subject := strings.Split(msg.Subject(), ".")
+	for _, route := range r.routes {
+		if params, ok := route.match(subject); ok {
+			return route.handler(ctx, process, msg, params)
+		}
+	}
+	if r.fallback != nil {
+		return r.fallback(ctx, process, msg)
+	}
+	return Terminate(fmt.Errorf("%w %s", ErrNoRoute, msg.Subject()))
+}
+
+// match reports whether subject is matched by the route, along with the
+// captured tokens
+func (rt route) match(subject []string) (SubjectParams, bool) {
+	var params SubjectParams
+	for i, token := range rt.tokens {
+		if token == ">" {
+			return params, i < len(subject)
+		}
+		if i >= len(subject) {
+			return nil, false
+		}
+		if name, ok := captureName(token); ok {
+			if params == nil {
+				params = make(SubjectParams)
+			}
+			params[name] = subject[i]
+			continue
+		}
+		if token != "*" && token != subject[i] {
+			return nil, false
+		}
+	}
+	return params, len(rt.tokens) == len(subject)
+}
+
+func captureName(token string) (string, bool) {
+	if strings.HasPrefix(token, "{") && strings.HasSuffix(token, "}") {
+		return token[1 : len(token)-1], true
+	}
+	return "", false
+}
@@ -0,0 +1,78 @@
+package ergonats
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestRouter(t *testing.T) {
+	var routed string
+	var captured SubjectParams
+	handler := func(name string) RouteHandler {
+		return func(_ context.Context, _ *PullConsumerProcess, _ jetstream.Msg, params SubjectParams) error {
+			routed = name
+			captured = params
+			return nil
+		}
+	}
+
+	router := NewRouter()
+	for pattern, name := range map[string]string{
+		"orders.{id}.created":        "created",
+		"orders.*.shipped.{carrier}": "shipped",
+		"audit.>":                    "audit",
+	} {
+		if err := router.Handle(pattern, handler(name)); err != nil {
+			t.Fatalf("failed to register %s: %s", pattern, err)
+		}
+	}
+
+	cases := []struct {
+		subject string
+		route   string
+		params  SubjectParams
+	}{
+		{"orders.42.created", "created", SubjectParams{"id": "42"}},
+		{"orders.42.shipped.ups", "shipped", SubjectParams{"carrier": "ups"}},
+		{"audit.orders.42", "audit", nil},
+	}
+	for _, c := range cases {
+		routed, captured = "", nil
+		if err := router.Route(context.Background(), nil, &fakeMsg{subject: c.subject}); err != nil {
+			t.Fatalf("%s: unexpected error %s", c.subject, err)
+		}
+		if routed != c.route || len(captured) != len(c.params) {
+			t.Fatalf("%s: routed to %q with %v", c.subject, routed, captured)
+		}
+		for name, value := range c.params {
+			if captured[name] != value {
+				t.Fatalf("%s: captured %v", c.subject, captured)
+			}
+		}
+	}
+
+	for _, subject := range []string{"orders.42", "orders.42.created.late", "audit"} {
+		err := router.Route(context.Background(), nil, &fakeMsg{subject: subject})
+		var term *TermError
+		if !errors.Is(err, ErrNoRoute) || !errors.As(err, &term) {
+			t.Fatalf("%s: expected no route, got %v", subject, err)
+		}
+	}
+
+	router.Fallback(func(_ context.Context, _ *PullConsumerProcess, _ jetstream.Msg) error {
+		routed = "fallback"
+		return nil
+	})
+	if err := router.Route(context.Background(), nil, &fakeMsg{subject: "other"}); err != nil || routed != "fallback" {
+		t.Fatalf("unmatched message did not reach the fallback: %v", err)
+	}
+
+	for _, pattern := range []string{"orders.{}.created", "orders.{id}.{id}", "orders.>.created", "orders..created"} {
+		if err := router.Handle(pattern, handler("bad")); err == nil {
+			t.Fatalf("%s should have been rejected", pattern)
+		}
+	}
+}
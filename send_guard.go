@@ -0,0 +1,46 @@
+package ergonats
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/ergo-services/ergo/gen"
+)
+
+var errProcessStopping = errors.New("process is stopping")
+
+// sendGuard guards the sends a Subscriber or a Service makes to itself from
+// NATS callbacks, the way sendSelf does for pull consumers. Ergo tears a
+// process's mailbox down without locking out concurrent senders, so nothing
+// is sent once Terminate called stop, and stop waits for a send in progress.
+type sendGuard struct {
+	sync.Mutex
+
+	stopping bool
+	active   sync.WaitGroup
+}
+
+// send calls send unless the process is stopping or its context is done
+func (g *sendGuard) send(process gen.Process, send func() error) error {
+	g.Lock()
+	if g.stopping {
+		g.Unlock()
+		return errProcessStopping
+	}
+	g.active.Add(1)
+	g.Unlock()
+	defer g.active.Done()
+
+	if err := process.Context().Err(); err != nil {
+		return err
+	}
+	return send()
+}
+
+// stop turns away further sends and returns once those in progress are done
+func (g *sendGuard) stop() {
+	g.Lock()
+	g.stopping = true
+	g.Unlock()
+	g.active.Wait()
+}
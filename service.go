@@ -0,0 +1,349 @@
+package ergonats
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/ergo-services/ergo/etf"
+	"github.com/ergo-services/ergo/gen"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+// messageServiceRequest carries a micro request into the process loop. done is
+// closed once the behavior has handled it.
+type messageServiceRequest struct {
+	endpoint string
+	request  micro.Request
+	done     chan struct{}
+}
+
+type ServiceBehavior interface {
+	gen.ServerBehavior
+
+	InitService(process *ServiceProcess, args ...etf.Term) (*ServiceOptions, error)
+	HandleRequest(process *ServiceProcess, endpoint string, request micro.Request) error
+}
+
+// ServiceCallHandler is an optional extension of ServiceBehavior for answering
+// synchronous requests made with Call
+type ServiceCallHandler interface {
+	HandleServiceCall(process *ServiceProcess, from gen.ServerFrom, message etf.Term) (etf.Term, gen.ServerStatus)
+}
+
+// ServiceInfoHandler is an optional extension of ServiceBehavior for receiving
+// regular messages such as timers and monitor notifications
+type ServiceInfoHandler interface {
+	HandleServiceInfo(process *ServiceProcess, message etf.Term) gen.ServerStatus
+}
+
+// ServiceDirectHandler is an optional extension of ServiceBehavior for
+// answering direct requests made with Direct
+type ServiceDirectHandler interface {
+	HandleServiceDirect(process *ServiceProcess, ref etf.Ref, message interface{}) (interface{}, gen.DirectStatus)
+}
+
+// ServiceStatsHandler is an optional extension of ServiceBehavior adding
+// custom data to each endpoint's STATS response. It is called by the micro
+// service outside the process loop, so it must only read state that is safe
+// for concurrent use.
+type ServiceStatsHandler interface {
+	HandleServiceStats(process *ServiceProcess, endpoint *micro.Endpoint) interface{}
+}
+
+// ServiceError can be returned from HandleRequest to respond with a specific
+// error code. Any other error is answered with code 500.
+type ServiceError struct {
+	Code        string
+	Description string
+	Data        []byte
+}
+
+func (e *ServiceError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Description)
+}
+
+// Service exposes a NATS micro service whose requests are handled inside the
+// process loop, one at a time
+type Service struct {
+	gen.Server
+}
+
+type ServiceOptions struct {
+	Logger      *slog.Logger
+	Connection  *nats.Conn
+	Name        string
+	Version     string
+	Description string
+	Metadata    map[string]string
+
+	// QueueGroup overrides the micro default queue group for every endpoint
+	QueueGroup string
+
+	// Endpoints are served at the root of the service and Groups under a
+	// subject prefix. Endpoint names must be unique across both.
+	Endpoints []ServiceEndpoint
+	Groups    []ServiceGroup
+}
+
+type ServiceGroup struct {
+	Name       string
+	QueueGroup string
+	Endpoints  []ServiceEndpoint
+}
+
+type ServiceEndpoint struct {
+	Name string
+
+	// Subject defaults to Name
+	Subject    string
+	QueueGroup string
+	Metadata   map[string]string
+}
+
+type ServiceProcess struct {
+	gen.ServerProcess
+
+	options  ServiceOptions
+	behavior ServiceBehavior
+	service  micro.Service
+	guard    *sendGuard
+}
+
+func (process *ServiceProcess) Options() *ServiceOptions {
+	return &process.options
+}
+
+// Service returns the running micro service
+func (process *ServiceProcess) Service() micro.Service {
+	return process.service
+}
+
+// gen.Server callbacks
+
+func (s *Service) Init(process *gen.ServerProcess, args ...etf.Term) error {
+	serviceProcess := &ServiceProcess{
+		ServerProcess: *process,
+		guard:         &sendGuard{},
+	}
+	serviceProcess.State = nil
+
+	behavior, ok := process.Behavior().(ServiceBehavior)
+	if !ok {
+		return fmt.Errorf("service: not a ServiceBehavior")
+	}
+	serviceProcess.behavior = behavior
+
+	opts, err := behavior.InitService(serviceProcess, args...)
+	if err != nil {
+		return err
+	}
+	if err := opts.validate(); err != nil {
+		return err
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	serviceProcess.options = *opts
+	process.State = serviceProcess
+
+	if err := serviceProcess.start(); err != nil {
+		return err
+	}
+
+	opts.Logger.Info("Service started",
+		slog.String("name", opts.Name),
+		slog.String("version", opts.Version),
+		slog.String("id", serviceProcess.service.Info().ID),
+	)
+	return nil
+}
+
+func (s *Service) HandleCast(process *gen.ServerProcess, message etf.Term) gen.ServerStatus {
+	p := process.State.(*ServiceProcess)
+	msg, ok := message.(messageServiceRequest)
+	if !ok {
+		return gen.ServerStatusOK
+	}
+	defer close(msg.done)
+
+	err := p.behavior.HandleRequest(p, msg.endpoint, msg.request)
+	if err == nil {
+		return gen.ServerStatusOK
+	}
+
+	p.options.Logger.Error("Failed to handle service request",
+		slog.String("endpoint", msg.endpoint),
+		slog.String("subject", msg.request.Subject()),
+		slog.Any("error", err),
+	)
+	var serviceErr *ServiceError
+	if errors.As(err, &serviceErr) {
+		_ = msg.request.Error(serviceErr.Code, serviceErr.Description, serviceErr.Data)
+	} else {
+		_ = msg.request.Error("500", err.Error(), nil)
+	}
+	return gen.ServerStatusOK
+}
+
+func (s *Service) HandleCall(
+	process *gen.ServerProcess,
+	from gen.ServerFrom,
+	message etf.Term) (etf.Term, gen.ServerStatus) {
+
+	p := process.State.(*ServiceProcess)
+	if handler, ok := p.behavior.(ServiceCallHandler); ok {
+		return handler.HandleServiceCall(p, from, message)
+	}
+	return etf.Atom("ok"), gen.ServerStatusOK
+}
+
+func (s *Service) HandleDirect(
+	process *gen.ServerProcess,
+	ref etf.Ref, message interface{}) (interface{}, gen.DirectStatus) {
+
+	p := process.State.(*ServiceProcess)
+	if handler, ok := p.behavior.(ServiceDirectHandler); ok {
+		return handler.HandleServiceDirect(p, ref, message)
+	}
+	return nil, fmt.Errorf("unsupported request")
+}
+
+func (s *Service) HandleInfo(process *gen.ServerProcess, message etf.Term) gen.ServerStatus {
+	p := process.State.(*ServiceProcess)
+	if handler, ok := p.behavior.(ServiceInfoHandler); ok {
+		return handler.HandleServiceInfo(p, message)
+	}
+	return gen.ServerStatusOK
+}
+
+func (s *Service) Terminate(process *gen.ServerProcess, _ string) {
+	p, ok := process.State.(*ServiceProcess)
+	if !ok || p.service == nil {
+		return
+	}
+	if err := p.service.Stop(); err != nil {
+		p.options.Logger.Warn("Failed to stop service",
+			slog.String("name", p.options.Name),
+			slog.Any("error", err),
+		)
+	}
+	p.guard.stop()
+}
+
+func (process *ServiceProcess) start() error {
+	opts := process.options
+	config := micro.Config{
+		Name:        opts.Name,
+		Version:     opts.Version,
+		Description: opts.Description,
+		Metadata:    opts.Metadata,
+		QueueGroup:  opts.QueueGroup,
+	}
+	if handler, ok := process.behavior.(ServiceStatsHandler); ok {
+		config.StatsHandler = func(endpoint *micro.Endpoint) any {
+			return handler.HandleServiceStats(process, endpoint)
+		}
+	}
+
+	service, err := micro.AddService(opts.Connection, config)
+	if err != nil {
+		return fmt.Errorf("failed to add service %s: %w", opts.Name, err)
+	}
+	process.service = service
+
+	for _, endpoint := range opts.Endpoints {
+		if err := process.addEndpoint(service, endpoint); err != nil {
+			_ = service.Stop()
+			return err
+		}
+	}
+	for _, group := range opts.Groups {
+		var groupOpts []micro.GroupOpt
+		if group.QueueGroup != "" {
+			groupOpts = append(groupOpts, micro.WithGroupQueueGroup(group.QueueGroup))
+		}
+		g := service.AddGroup(group.Name, groupOpts...)
+		for _, endpoint := range group.Endpoints {
+			if err := process.addEndpoint(g, endpoint); err != nil {
+				_ = service.Stop()
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (process *ServiceProcess) addEndpoint(group micro.Group, endpoint ServiceEndpoint) error {
+	var endpointOpts []micro.EndpointOpt
+	if endpoint.Subject != "" {
+		endpointOpts = append(endpointOpts, micro.WithEndpointSubject(endpoint.Subject))
+	}
+	if endpoint.QueueGroup != "" {
+		endpointOpts = append(endpointOpts, micro.WithEndpointQueueGroup(endpoint.QueueGroup))
+	}
+	if endpoint.Metadata != nil {
+		endpointOpts = append(endpointOpts, micro.WithEndpointMetadata(endpoint.Metadata))
+	}
+
+	if err := group.AddEndpoint(endpoint.Name, process.handler(endpoint.Name), endpointOpts...); err != nil {
+		return fmt.Errorf("failed to add endpoint %s: %w", endpoint.Name, err)
+	}
+	return nil
+}
+
+// handler casts each request to the process and waits until it is handled so
+// that the micro stats account for the time spent in the behavior
+func (process *ServiceProcess) handler(endpoint string) micro.Handler {
+	return micro.HandlerFunc(func(request micro.Request) {
+		done := make(chan struct{})
+		msg := messageServiceRequest{endpoint: endpoint, request: request, done: done}
+		err := process.guard.send(process, func() error {
+			return process.Cast(process.Self(), msg)
+		})
+		if err != nil {
+			_ = request.Error("503", "service unavailable", nil)
+			return
+		}
+		select {
+		case <-done:
+		case <-process.Context().Done():
+		}
+	})
+}
+
+func (opts ServiceOptions) validate() error {
+	if opts.Connection == nil {
+		return invalidOption("service", "Connection", "a NATS connection is required")
+	}
+	if strings.TrimSpace(opts.Name) == "" {
+		return invalidOption("service", "Name", "a service name is required")
+	}
+	if strings.TrimSpace(opts.Version) == "" {
+		return invalidOption("service", "Version", "a service version is required")
+	}
+
+	names := make(map[string]bool)
+	endpoints := opts.Endpoints
+	for _, group := range opts.Groups {
+		if !validSubject(group.Name, true) {
+			return invalidOption("service", "Groups", "%q is not a legal subject prefix", group.Name)
+		}
+		endpoints = append(endpoints[:len(endpoints):len(endpoints)], group.Endpoints...)
+	}
+	if len(endpoints) == 0 {
+		return invalidOption("service", "Endpoints", "at least one endpoint is required")
+	}
+	for _, endpoint := range endpoints {
+		if strings.TrimSpace(endpoint.Name) == "" {
+			return invalidOption("service", "Endpoints", "an endpoint name is required")
+		}
+		if names[endpoint.Name] {
+			return invalidOption("service", "Endpoints", "endpoint %q is declared twice", endpoint.Name)
+		}
+		names[endpoint.Name] = true
+	}
+	return nil
+}
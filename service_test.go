@@ -0,0 +1,140 @@
+package ergonats
+
+import (
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ergo-services/ergo/etf"
+	"github.com/ergo-services/ergo/gen"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+type testService struct {
+	Service
+
+	opts    ServiceOptions
+	created atomic.Int32
+}
+
+func (s *testService) InitService(_ *ServiceProcess, _ ...etf.Term) (*ServiceOptions, error) {
+	opts := s.opts
+	return &opts, nil
+}
+
+func (s *testService) HandleRequest(_ *ServiceProcess, endpoint string, request micro.Request) error {
+	switch endpoint {
+	case "ping":
+		return request.Respond([]byte("pong"))
+	case "create":
+		if len(request.Data()) == 0 {
+			return &ServiceError{Code: "400", Description: "empty order"}
+		}
+		s.created.Add(1)
+		return request.Respond(request.Data())
+	}
+	return errors.New("unexpected endpoint")
+}
+
+func (s *testService) HandleServiceStats(_ *ServiceProcess, endpoint *micro.Endpoint) interface{} {
+	if endpoint.Name == "create" {
+		return map[string]int32{"created": s.created.Load()}
+	}
+	return nil
+}
+
+func TestServiceHandlesRequests(t *testing.T) {
+	shutdown, nc := startNatsServer(t)
+	defer shutdown()
+
+	n := startTestNode(t)
+	defer n.Stop()
+
+	service := &testService{
+		opts: ServiceOptions{
+			Connection: nc,
+			Name:       "orders",
+			Version:    "1.0.0",
+			Endpoints:  []ServiceEndpoint{{Name: "ping"}},
+			Groups: []ServiceGroup{{
+				Name:      "orders",
+				Endpoints: []ServiceEndpoint{{Name: "create"}},
+			}},
+		},
+	}
+	p, err := n.Spawn("orders", gen.ProcessOptions{}, service)
+	if err != nil {
+		t.Fatalf("failed to spawn service: %s", err)
+	}
+
+	reply, err := nc.Request("ping", nil, time.Second)
+	if err != nil || string(reply.Data) != "pong" {
+		t.Fatalf("unexpected ping reply: %v %v", reply, err)
+	}
+	reply, err = nc.Request("orders.create", []byte("order-1"), time.Second)
+	if err != nil || string(reply.Data) != "order-1" {
+		t.Fatalf("unexpected create reply: %v %v", reply, err)
+	}
+	reply, err = nc.Request("orders.create", nil, time.Second)
+	if err != nil || reply.Header.Get(micro.ErrorCodeHeader) != "400" {
+		t.Fatalf("expected a 400 error reply: %v %v", reply, err)
+	}
+
+	reply, err = nc.Request("$SRV.STATS.orders", nil, time.Second)
+	if err != nil {
+		t.Fatalf("failed to request stats: %s", err)
+	}
+	var stats micro.Stats
+	if err := json.Unmarshal(reply.Data, &stats); err != nil {
+		t.Fatalf("failed to decode stats: %s", err)
+	}
+	var found bool
+	for _, endpoint := range stats.Endpoints {
+		if endpoint.Name != "create" {
+			continue
+		}
+		found = true
+		if endpoint.NumRequests != 2 || endpoint.NumErrors != 1 || string(endpoint.Data) != `{"created":1}` {
+			t.Fatalf("unexpected create stats: %+v", endpoint)
+		}
+	}
+	if !found {
+		t.Fatalf("create endpoint missing from stats: %+v", stats)
+	}
+
+	p.Exit("normal")
+	waitFor(t, func() bool {
+		_, err := nc.Request("ping", nil, 100*time.Millisecond)
+		return errors.Is(err, nats.ErrNoResponders)
+	})
+}
+
+func TestServiceRejectsInvalidOptions(t *testing.T) {
+	shutdown, nc := startNatsServer(t)
+	defer shutdown()
+
+	n := startTestNode(t)
+	defer n.Stop()
+
+	ping := []ServiceEndpoint{{Name: "ping"}}
+	for _, opts := range []ServiceOptions{
+		{Name: "svc", Version: "1.0.0", Endpoints: ping},
+		{Connection: nc, Version: "1.0.0", Endpoints: ping},
+		{Connection: nc, Name: "svc", Endpoints: ping},
+		{Connection: nc, Name: "svc", Version: "1.0.0"},
+		{Connection: nc, Name: "svc", Version: "1.0.0", Endpoints: ping, Groups: []ServiceGroup{{Name: "g", Endpoints: ping}}},
+		{Connection: nc, Name: "svc", Version: "not-semver", Endpoints: ping},
+	} {
+		_, err := n.Spawn("", gen.ProcessOptions{}, &testService{opts: opts})
+		if err == nil {
+			t.Fatalf("expected %+v to be rejected", opts)
+		}
+		var optsErr *OptionsError
+		if errors.As(err, &optsErr) && optsErr.Component != "service" {
+			t.Fatalf("service options reported as %s options: %s", optsErr.Component, err)
+		}
+	}
+}
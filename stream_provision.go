@@ -0,0 +1,125 @@
+package ergonats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// StreamDriftPolicy decides what happens when an existing stream's config
+// differs from the one declared in PullConsumerOptions.StreamConfig
+type StreamDriftPolicy int
+
+const (
+	// StreamDriftPolicyWarn logs the drift and carries on with the existing stream
+	StreamDriftPolicyWarn StreamDriftPolicy = iota
+	// StreamDriftPolicyError fails attachment with a StreamDriftError
+	StreamDriftPolicyError
+	// StreamDriftPolicyUpdate updates the stream to the declared config
+	StreamDriftPolicyUpdate
+)
+
+// StreamDriftError lists the fields in which an existing stream differs from
+// its declared config
+type StreamDriftError struct {
+	Stream string
+	Fields []string
+}
+
+func (e *StreamDriftError) Error() string {
+	return fmt.Sprintf("stream %s drifted from its declared config: %s",
+		e.Stream, strings.Join(e.Fields, ", "))
+}
+
+// attachStream looks up the consumer's stream, creating it from the declared
+// StreamConfig when it is missing and checking it for drift when it is not
+func (opts *PullConsumerOptions) attachStream(ctx context.Context, js jetstream.JetStream) (jetstream.Stream, error) {
+	declared := opts.StreamConfig
+	stream, err := js.Stream(ctx, opts.StreamName)
+	if declared == nil {
+		return stream, err
+	}
+
+	if errors.Is(err, jetstream.ErrStreamNotFound) {
+		opts.Logger.Info("Creating declared stream", slog.String("stream", declared.Name))
+		return js.CreateStream(ctx, *declared)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	drift := streamDrift(*declared, stream.CachedInfo().Config)
+	if len(drift) == 0 {
+		return stream, nil
+	}
+
+	switch opts.StreamDriftPolicy {
+	case StreamDriftPolicyError:
+		return nil, &StreamDriftError{Stream: declared.Name, Fields: drift}
+	case StreamDriftPolicyUpdate:
+		opts.Logger.Info("Updating drifted stream",
+			slog.String("stream", declared.Name),
+			slog.Any("fields", drift),
+		)
+		return js.UpdateStream(ctx, *declared)
+	default:
+		opts.Logger.Warn("Stream drifted from its declared config",
+			slog.String("stream", declared.Name),
+			slog.Any("fields", drift),
+		)
+		return stream, nil
+	}
+}
+
+// streamDrift returns the names of the fields in which actual differs from
+// declared. Limits left at zero in the declared config take the server's
+// default and are not compared.
+func streamDrift(declared, actual jetstream.StreamConfig) []string {
+	var drift []string
+	check := func(field string, differs bool) {
+		if differs {
+			drift = append(drift, field)
+		}
+	}
+
+	check("Subjects", !sameSubjects(declared.Subjects, actual.Subjects))
+	check("Retention", declared.Retention != actual.Retention)
+	check("Storage", declared.Storage != actual.Storage)
+	check("Discard", declared.Discard != actual.Discard)
+	check("Description", declared.Description != "" && declared.Description != actual.Description)
+	check("MaxConsumers", declared.MaxConsumers != 0 && declared.MaxConsumers != actual.MaxConsumers)
+	check("MaxMsgs", declared.MaxMsgs != 0 && declared.MaxMsgs != actual.MaxMsgs)
+	check("MaxBytes", declared.MaxBytes != 0 && declared.MaxBytes != actual.MaxBytes)
+	check("MaxAge", declared.MaxAge != 0 && declared.MaxAge != actual.MaxAge)
+	check("MaxMsgsPerSubject", declared.MaxMsgsPerSubject != 0 && declared.MaxMsgsPerSubject != actual.MaxMsgsPerSubject)
+	check("MaxMsgSize", declared.MaxMsgSize != 0 && declared.MaxMsgSize != actual.MaxMsgSize)
+	check("Replicas", declared.Replicas > 1 && declared.Replicas != actual.Replicas)
+	check("Duplicates", declared.Duplicates != 0 && declared.Duplicates != actual.Duplicates)
+	check("NoAck", declared.NoAck != actual.NoAck)
+	check("DenyDelete", declared.DenyDelete != actual.DenyDelete)
+	check("DenyPurge", declared.DenyPurge != actual.DenyPurge)
+	check("AllowRollup", declared.AllowRollup != actual.AllowRollup)
+
+	return drift
+}
+
+func sameSubjects(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,74 @@
+package ergonats
+
+import "strings"
+
+// validName reports whether name can be used as a stream or consumer name
+func validName(name string) bool {
+	if name == "" || len(name) > 255 {
+		return false
+	}
+	return !strings.ContainsAny(name, " \t\r\n.*>/\\")
+}
+
+// validSubject reports whether subject is a well-formed NATS subject. The
+// `*` and `>` wildcards are only accepted when wildcards is true, and `>` only
+// as the last token.
+func validSubject(subject string, wildcards bool) bool {
+	if subject == "" || strings.ContainsAny(subject, " \t\r\n") {
+		return false
+	}
+	tokens := strings.Split(subject, ".")
+	for i, token := range tokens {
+		switch {
+		case token == "":
+			return false
+		case token == "*":
+			if !wildcards {
+				return false
+			}
+		case token == ">":
+			if !wildcards || i != len(tokens)-1 {
+				return false
+			}
+		case strings.ContainsAny(token, "*>"):
+			return false
+		}
+	}
+	return true
+}
+
+// subjectSubsetOf reports whether every subject matched by subject is also
+// matched by pattern. Both may contain wildcards.
+func subjectSubsetOf(subject, pattern string) bool {
+	sub := strings.Split(subject, ".")
+	pat := strings.Split(pattern, ".")
+
+	for i, token := range sub {
+		if i >= len(pat) {
+			return false
+		}
+		switch pat[i] {
+		case ">":
+			return true
+		case "*":
+			if token == ">" {
+				return false
+			}
+		default:
+			if token != pat[i] {
+				return false
+			}
+		}
+	}
+	return len(sub) == len(pat)
+}
+
+// subjectCovered reports whether subject is a subset of any of the patterns
+func subjectCovered(subject string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if subjectSubsetOf(subject, pattern) {
+			return true
+		}
+	}
+	return false
+}
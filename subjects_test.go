@@ -0,0 +1,50 @@
+package ergonats
+
+import "testing"
+
+func TestValidSubject(t *testing.T) {
+	cases := []struct {
+		subject   string
+		wildcards bool
+		valid     bool
+	}{
+		{"orders.created", false, true},
+		{"orders.*.created", true, true},
+		{"orders.*.created", false, false},
+		{"orders.>", true, true},
+		{"orders.>.created", true, false},
+		{"orders..created", true, false},
+		{"orders.cre*ted", true, false},
+		{"orders created", false, false},
+		{"", true, false},
+	}
+	for _, c := range cases {
+		if validSubject(c.subject, c.wildcards) != c.valid {
+			t.Fatalf("validSubject(%q, %v) should be %v", c.subject, c.wildcards, c.valid)
+		}
+	}
+}
+
+func TestSubjectSubsetOf(t *testing.T) {
+	cases := []struct {
+		subject string
+		pattern string
+		subset  bool
+	}{
+		{"orders.created", "orders.created", true},
+		{"orders.created", "orders.*", true},
+		{"orders.1.created", "orders.>", true},
+		{"orders.*.created", "orders.>", true},
+		{"orders.>", "orders.>", true},
+		{"orders.>", "orders.*", false},
+		{"orders.*", "orders.created", false},
+		{"orders", "orders.>", false},
+		{"orders.1.created", "orders.*", false},
+		{"payments.created", "orders.>", false},
+	}
+	for _, c := range cases {
+		if subjectSubsetOf(c.subject, c.pattern) != c.subset {
+			t.Fatalf("subjectSubsetOf(%q, %q) should be %v", c.subject, c.pattern, c.subset)
+		}
+	}
+}
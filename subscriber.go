@@ -0,0 +1,247 @@
+package ergonats
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+
+	"github.com/ergo-services/ergo/etf"
+	"github.com/ergo-services/ergo/gen"
+	"github.com/nats-io/nats.go"
+)
+
+type SubscriberBehavior interface {
+	gen.ServerBehavior
+
+	InitSubscriber(process *SubscriberProcess, args ...etf.Term) (*SubscriberOptions, error)
+	HandleNatsMessage(process *SubscriberProcess, msg *nats.Msg) error
+}
+
+// SubscriberCallHandler is an optional extension of SubscriberBehavior for
+// answering synchronous requests made with Call
+type SubscriberCallHandler interface {
+	HandleSubscriberCall(process *SubscriberProcess, from gen.ServerFrom, message etf.Term) (etf.Term, gen.ServerStatus)
+}
+
+// SubscriberInfoHandler is an optional extension of SubscriberBehavior for
+// receiving regular messages, including MessageNatsSlowConsumer
+type SubscriberInfoHandler interface {
+	HandleSubscriberInfo(process *SubscriberProcess, message etf.Term) gen.ServerStatus
+}
+
+// SubscriberDirectHandler is an optional extension of SubscriberBehavior for
+// answering direct requests made with Direct
+type SubscriberDirectHandler interface {
+	HandleSubscriberDirect(process *SubscriberProcess, ref etf.Ref, message interface{}) (interface{}, gen.DirectStatus)
+}
+
+// Subscriber receives core NATS messages on one or more subjects
+type Subscriber struct {
+	gen.Server
+}
+
+type SubscriberOptions struct {
+	Logger     *slog.Logger
+	Connection *nats.Conn
+	Subjects   []string
+
+	// QueueGroup, when set, shares the subjects' messages between every
+	// subscriber in the group
+	QueueGroup string
+
+	// MaxPending bounds the number of messages waiting in the process
+	// mailbox. Further messages are dropped, and the behavior is sent a single
+	// MessageNatsSlowConsumer until the mailbox holds no more messages. Zero
+	// means unbounded.
+	MaxPending int
+}
+
+type SubscriberProcess struct {
+	gen.ServerProcess
+
+	options  SubscriberOptions
+	behavior SubscriberBehavior
+	subs     *subscriberState
+}
+
+// subscriberState is shared with the NATS subscription callbacks
+type subscriberState struct {
+	guard         sendGuard
+	subscriptions []*nats.Subscription
+	pending       atomic.Int64
+	slow          atomic.Bool
+	dropped       atomic.Uint64
+}
+
+func (process *SubscriberProcess) Options() *SubscriberOptions {
+	return &process.options
+}
+
+// Dropped returns how many messages were dropped because MaxPending was
+// exceeded
+func (process *SubscriberProcess) Dropped() uint64 {
+	return process.subs.dropped.Load()
+}
+
+// gen.Server callbacks
+
+func (s *Subscriber) Init(process *gen.ServerProcess, args ...etf.Term) error {
+	subscriberProcess := &SubscriberProcess{
+		ServerProcess: *process,
+		subs:          &subscriberState{},
+	}
+	subscriberProcess.State = nil
+
+	behavior, ok := process.Behavior().(SubscriberBehavior)
+	if !ok {
+		return fmt.Errorf("subscriber: not a SubscriberBehavior")
+	}
+	subscriberProcess.behavior = behavior
+
+	opts, err := behavior.InitSubscriber(subscriberProcess, args...)
+	if err != nil {
+		return err
+	}
+	if err := opts.validate(); err != nil {
+		return err
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	subscriberProcess.options = *opts
+	process.State = subscriberProcess
+
+	for _, subject := range opts.Subjects {
+		sub, err := opts.Connection.QueueSubscribe(subject, opts.QueueGroup, subscriberProcess.deliver)
+		if err != nil {
+			subscriberProcess.unsubscribe()
+			return fmt.Errorf("failed to subscribe to %s: %w", subject, err)
+		}
+		subscriberProcess.subs.subscriptions = append(subscriberProcess.subs.subscriptions, sub)
+	}
+
+	return nil
+}
+
+func (s *Subscriber) HandleCast(process *gen.ServerProcess, message etf.Term) gen.ServerStatus {
+	p := process.State.(*SubscriberProcess)
+	msg, ok := message.(*nats.Msg)
+	if !ok {
+		return gen.ServerStatusOK
+	}
+
+	if p.subs.pending.Add(-1) == 0 {
+		// the backlog cleared, so the next drop is reported again
+		p.subs.slow.Store(false)
+	}
+	if err := p.behavior.HandleNatsMessage(p, msg); err != nil {
+		p.options.Logger.Error("Failed to handle NATS message",
+			slog.String("subject", msg.Subject),
+			slog.Any("error", err),
+		)
+	}
+	return gen.ServerStatusOK
+}
+
+func (s *Subscriber) HandleCall(
+	process *gen.ServerProcess,
+	from gen.ServerFrom,
+	message etf.Term) (etf.Term, gen.ServerStatus) {
+
+	p := process.State.(*SubscriberProcess)
+	if handler, ok := p.behavior.(SubscriberCallHandler); ok {
+		return handler.HandleSubscriberCall(p, from, message)
+	}
+	return etf.Atom("ok"), gen.ServerStatusOK
+}
+
+func (s *Subscriber) HandleDirect(
+	process *gen.ServerProcess,
+	ref etf.Ref, message interface{}) (interface{}, gen.DirectStatus) {
+
+	p := process.State.(*SubscriberProcess)
+	if handler, ok := p.behavior.(SubscriberDirectHandler); ok {
+		return handler.HandleSubscriberDirect(p, ref, message)
+	}
+	return nil, fmt.Errorf("unsupported request")
+}
+
+func (s *Subscriber) HandleInfo(process *gen.ServerProcess, message etf.Term) gen.ServerStatus {
+	p := process.State.(*SubscriberProcess)
+	if handler, ok := p.behavior.(SubscriberInfoHandler); ok {
+		return handler.HandleSubscriberInfo(p, message)
+	}
+	return gen.ServerStatusOK
+}
+
+func (s *Subscriber) Terminate(process *gen.ServerProcess, _ string) {
+	p, ok := process.State.(*SubscriberProcess)
+	if !ok {
+		return
+	}
+	p.unsubscribe()
+	p.subs.guard.stop()
+}
+
+// deliver casts msg to the process unless the backlog is full, in which case
+// the message is dropped and the behavior told once per backlog
+func (process *SubscriberProcess) deliver(msg *nats.Msg) {
+	max := int64(process.options.MaxPending)
+	if max > 0 && process.subs.pending.Load() >= max {
+		process.subs.dropped.Add(1)
+		if process.subs.slow.CompareAndSwap(false, true) {
+			process.options.Logger.Warn("Subscriber is falling behind, dropping messages",
+				slog.String("subject", msg.Subject),
+				slog.Int("max_pending", process.options.MaxPending),
+			)
+			slow := MessageNatsSlowConsumer{
+				Conn:    process.options.Connection,
+				Subject: msg.Subject,
+			}
+			_ = process.subs.guard.send(process, func() error {
+				return process.Send(process.Self(), slow)
+			})
+		}
+		return
+	}
+
+	process.subs.pending.Add(1)
+	err := process.subs.guard.send(process, func() error {
+		return process.Cast(process.Self(), msg)
+	})
+	if err != nil {
+		process.subs.pending.Add(-1)
+	}
+}
+
+func (process *SubscriberProcess) unsubscribe() {
+	for _, sub := range process.subs.subscriptions {
+		_ = sub.Unsubscribe()
+	}
+	process.subs.subscriptions = nil
+}
+
+func (opts SubscriberOptions) validate() error {
+	if opts.Connection == nil {
+		return invalidOption("subscriber", "Connection", "a NATS connection is required")
+	}
+	if opts.Connection.IsClosed() {
+		return invalidOption("subscriber", "Connection", "the NATS connection is closed")
+	}
+	if len(opts.Subjects) == 0 {
+		return invalidOption("subscriber", "Subjects", "at least one subject is required")
+	}
+	for _, subject := range opts.Subjects {
+		if !validSubject(subject, true) {
+			return invalidOption("subscriber", "Subjects", "%q is not a legal subject", subject)
+		}
+	}
+	if opts.QueueGroup != "" && strings.ContainsAny(opts.QueueGroup, " \t\r\n") {
+		return invalidOption("subscriber", "QueueGroup", "%q is not a legal queue group", opts.QueueGroup)
+	}
+	if opts.MaxPending < 0 {
+		return invalidOption("subscriber", "MaxPending", "must not be negative")
+	}
+	return nil
+}
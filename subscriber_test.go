@@ -0,0 +1,206 @@
+package ergonats
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ergo-services/ergo/etf"
+	"github.com/ergo-services/ergo/gen"
+	"github.com/nats-io/nats.go"
+)
+
+type testSubscriber struct {
+	Subscriber
+
+	opts    SubscriberOptions
+	block   chan struct{}
+	process *SubscriberProcess
+	entered atomic.Int32
+	handled atomic.Int32
+
+	mu   sync.Mutex
+	slow []MessageNatsSlowConsumer
+	seen map[string]int
+}
+
+func (s *testSubscriber) InitSubscriber(process *SubscriberProcess, _ ...etf.Term) (*SubscriberOptions, error) {
+	s.process = process
+	opts := s.opts
+	return &opts, nil
+}
+
+func (s *testSubscriber) HandleNatsMessage(_ *SubscriberProcess, msg *nats.Msg) error {
+	s.entered.Add(1)
+	if s.seen != nil {
+		s.mu.Lock()
+		s.seen[msg.Subject+":"+string(msg.Data)]++
+		s.mu.Unlock()
+	}
+	if s.block != nil {
+		<-s.block
+	}
+	s.handled.Add(1)
+	return nil
+}
+
+func (s *testSubscriber) HandleSubscriberDirect(process *SubscriberProcess, _ etf.Ref, _ interface{}) (interface{}, gen.DirectStatus) {
+	return process.Dropped(), nil
+}
+
+func (s *testSubscriber) HandleSubscriberInfo(_ *SubscriberProcess, message etf.Term) gen.ServerStatus {
+	if msg, ok := message.(MessageNatsSlowConsumer); ok {
+		s.mu.Lock()
+		s.slow = append(s.slow, msg)
+		s.mu.Unlock()
+	}
+	return gen.ServerStatusOK
+}
+
+func TestSubscriberQueueGroup(t *testing.T) {
+	shutdown, nc := startNatsServer(t)
+	defer shutdown()
+
+	n := startTestNode(t)
+	defer n.Stop()
+
+	var subscribers []*testSubscriber
+	for _, name := range []string{"sub_a", "sub_b"} {
+		sub := &testSubscriber{
+			opts: SubscriberOptions{
+				Connection: nc,
+				Subjects:   []string{"orders.>", "payments.*"},
+				QueueGroup: "workers",
+			},
+			seen: make(map[string]int),
+		}
+		if _, err := n.Spawn(name, gen.ProcessOptions{}, sub); err != nil {
+			t.Fatalf("failed to spawn subscriber: %s", err)
+		}
+		subscribers = append(subscribers, sub)
+	}
+
+	for i := 0; i < 10; i++ {
+		_ = nc.Publish("orders.new", []byte(strconv.Itoa(i)))
+		_ = nc.Publish("payments.settled", []byte(strconv.Itoa(i)))
+	}
+	_ = nc.Publish("ignored", nil)
+	_ = nc.Flush()
+
+	waitFor(t, func() bool {
+		return subscribers[0].handled.Load()+subscribers[1].handled.Load() == 20
+	})
+	// without the queue group both subscribers would see every message
+	seen := make(map[string]int)
+	for _, sub := range subscribers {
+		sub.mu.Lock()
+		for key, count := range sub.seen {
+			seen[key] += count
+		}
+		sub.mu.Unlock()
+	}
+	for key, count := range seen {
+		if count != 1 {
+			t.Fatalf("%s was handled %d times", key, count)
+		}
+	}
+	if len(seen) != 20 {
+		t.Fatalf("queue group delivered %d distinct messages, expected 20", len(seen))
+	}
+}
+
+func TestSubscriberDropsBeyondMaxPending(t *testing.T) {
+	shutdown, nc := startNatsServer(t)
+	defer shutdown()
+
+	n := startTestNode(t)
+	defer n.Stop()
+
+	sub := &testSubscriber{
+		opts: SubscriberOptions{
+			Connection: nc,
+			Subjects:   []string{"bursty"},
+			MaxPending: 3,
+		},
+		block: make(chan struct{}),
+	}
+	p, err := n.Spawn("slow", gen.ProcessOptions{}, sub)
+	if err != nil {
+		t.Fatalf("failed to spawn subscriber: %s", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		_ = nc.Publish("bursty", nil)
+	}
+	_ = nc.Flush()
+	// every message is either being handled, waiting in the mailbox or dropped
+	waitFor(t, func() bool {
+		accounted := int(sub.entered.Load()) + int(sub.process.subs.pending.Load()) + int(sub.process.Dropped())
+		return accounted == 10
+	})
+
+	// let one message through and overflow the backlog again before it clears
+	sub.block <- struct{}{}
+	waitFor(t, func() bool { return sub.entered.Load() == 2 })
+	for i := 0; i < 5; i++ {
+		_ = nc.Publish("bursty", nil)
+	}
+	_ = nc.Flush()
+	waitFor(t, func() bool {
+		accounted := int(sub.entered.Load()) + int(sub.process.subs.pending.Load()) + int(sub.process.Dropped())
+		return accounted == 15
+	})
+	close(sub.block)
+
+	var dropped uint64
+	waitFor(t, func() bool {
+		reply, err := p.Direct("dropped")
+		if err != nil {
+			return false
+		}
+		dropped = reply.(uint64)
+		return int(dropped)+int(sub.handled.Load()) == 15
+	})
+	if dropped == 0 {
+		t.Fatalf("no messages were dropped")
+	}
+
+	waitFor(t, func() bool {
+		sub.mu.Lock()
+		defer sub.mu.Unlock()
+		return len(sub.slow) > 0
+	})
+	sub.mu.Lock()
+	slow := sub.slow
+	sub.mu.Unlock()
+	if len(slow) != 1 || slow[0].Subject != "bursty" {
+		t.Fatalf("expected a single slow consumer notification: %+v", slow)
+	}
+
+	p.Exit("normal")
+	waitFor(t, func() bool { return nc.NumSubscriptions() == 0 })
+}
+
+func TestSubscriberRejectsInvalidOptions(t *testing.T) {
+	shutdown, nc := startNatsServer(t)
+	defer shutdown()
+
+	n := startTestNode(t)
+	defer n.Stop()
+
+	for _, opts := range []SubscriberOptions{
+		{Subjects: []string{"a"}},
+		{Connection: nc},
+		{Connection: nc, Subjects: []string{"a..b"}},
+		{Connection: nc, Subjects: []string{"a"}, QueueGroup: "bad group"},
+		{Connection: nc, Subjects: []string{"a"}, MaxPending: -1},
+	} {
+		_, err := n.Spawn("", gen.ProcessOptions{}, &testSubscriber{opts: opts})
+		var optsErr *OptionsError
+		if !errors.Is(err, ErrInvalidOptions) || !errors.As(err, &optsErr) || optsErr.Component != "subscriber" {
+			t.Fatalf("expected %+v to be rejected, got %v", opts, err)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package ergonats
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/ergo-services/ergo/etf"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// PoisonPolicy decides what happens to a message whose payload cannot be
+// decoded
+type PoisonPolicy int
+
+const (
+	// PoisonTerm terminates the message so it is never redelivered
+	PoisonTerm PoisonPolicy = iota
+	// PoisonNak naks the message so it is redelivered
+	PoisonNak
+	// PoisonDeadLetter republishes the message to the consumer's dead-letter
+	// subject and terminates it
+	PoisonDeadLetter
+)
+
+type TypedPullConsumerBehavior[T any] interface {
+	PullConsumerBehavior
+
+	InitTypedPullConsumer(process *PullConsumerProcess, args ...etf.Term) (*TypedPullConsumerOptions[T], error)
+	HandleTypedMessage(process *PullConsumerProcess, msg TypedMessage[T]) error
+}
+
+// TypedPullConsumer decodes every message with a Codec before handing it to
+// HandleTypedMessage. Its options are kept on each process, as one behavior
+// value may be spawned many times, as by PullConsumerPool.
+type TypedPullConsumer[T any] struct {
+	PullConsumer
+}
+
+type TypedPullConsumerOptions[T any] struct {
+	PullConsumerOptions
+
+	// Codec defaults to JSONCodec
+	Codec        Codec[T]
+	PoisonPolicy PoisonPolicy
+}
+
+// TypedMessage is a decoded message along with the original JetStream message
+// and its metadata. Metadata is nil for messages without JetStream metadata.
+type TypedMessage[T any] struct {
+	Value    T
+	Msg      jetstream.Msg
+	Metadata *jetstream.MsgMetadata
+}
+
+func (m TypedMessage[T]) Subject() string {
+	return m.Msg.Subject()
+}
+
+func (m TypedMessage[T]) Headers() nats.Header {
+	return m.Msg.Headers()
+}
+
+func (c *TypedPullConsumer[T]) InitPullConsumer(
+	process *PullConsumerProcess,
+	args ...etf.Term) (*PullConsumerOptions, error) {
+
+	behavior, ok := process.Behavior().(TypedPullConsumerBehavior[T])
+	if !ok {
+		return nil, fmt.Errorf("typed consumer: not a TypedPullConsumerBehavior")
+	}
+
+	typedOpts, err := behavior.InitTypedPullConsumer(process, args...)
+	if err != nil {
+		return nil, err
+	}
+	if typedOpts.Codec == nil {
+		typedOpts.Codec = JSONCodec[T]()
+	}
+	if typedOpts.PoisonPolicy == PoisonDeadLetter && typedOpts.DeadLetter == nil {
+		return nil, invalid("PoisonPolicy", "dead-lettering poison messages requires DeadLetter")
+	}
+	process.typed = typedOpts
+
+	return &typedOpts.PullConsumerOptions, nil
+}
+
+func (c *TypedPullConsumer[T]) HandleMessage(process *PullConsumerProcess, msg jetstream.Msg) error {
+	behavior := process.Behavior().(TypedPullConsumerBehavior[T])
+	opts := process.typed.(*TypedPullConsumerOptions[T])
+
+	value, err := opts.Codec.Decode(msg.Data(), msg.Headers())
+	if err != nil {
+		return c.poison(process, opts.PoisonPolicy, msg, fmt.Errorf("failed to decode message: %w", err))
+	}
+
+	typed := TypedMessage[T]{
+		Value: value,
+		Msg:   msg,
+	}
+	if meta, err := msg.Metadata(); err == nil {
+		typed.Metadata = meta
+	}
+
+	return behavior.HandleTypedMessage(process, typed)
+}
+
+// poison settles a message that could not be decoded according to the
+// poison policy
+func (c *TypedPullConsumer[T]) poison(process *PullConsumerProcess, policy PoisonPolicy, msg jetstream.Msg, err error) error {
+	process.Options().Logger.Warn("Poison message",
+		slog.String("subject", msg.Subject()),
+		slog.Any("error", err),
+	)
+
+	switch policy {
+	case PoisonNak:
+		_ = msg.Nak()
+	case PoisonDeadLetter:
+		if dlqErr := process.DeadLetter(msg, err); dlqErr != nil {
+			process.Options().Logger.Error("Failed to dead-letter poison message", slog.Any("error", dlqErr))
+			_ = msg.Nak()
+		}
+	default:
+		_ = msg.TermWithReason(err.Error())
+	}
+
+	return alreadySettled(err)
+}
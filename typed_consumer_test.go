@@ -0,0 +1,180 @@
+package ergonats
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ergo-services/ergo/etf"
+	"github.com/ergo-services/ergo/gen"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+type orderPlaced struct {
+	OrderID string `json:"order_id"`
+	Amount  int    `json:"amount"`
+}
+
+type orderConsumer struct {
+	TypedPullConsumer[orderPlaced]
+
+	opts TypedPullConsumerOptions[orderPlaced]
+
+	lock     sync.Mutex
+	received []TypedMessage[orderPlaced]
+}
+
+func (c *orderConsumer) InitTypedPullConsumer(
+	_ *PullConsumerProcess,
+	_ ...etf.Term) (*TypedPullConsumerOptions[orderPlaced], error) {
+
+	opts := c.opts
+	return &opts, nil
+}
+
+func (c *orderConsumer) HandleTypedMessage(_ *PullConsumerProcess, msg TypedMessage[orderPlaced]) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.received = append(c.received, msg)
+	return nil
+}
+
+func (c *orderConsumer) count() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.received)
+}
+
+func TestTypedPullConsumer(t *testing.T) {
+	shutdown, nc := startNatsServer(t)
+	defer shutdown()
+
+	js := createTestStream(t, nc, "TYPED", "typed.>")
+	raw, _ := json.Marshal(orderPlaced{OrderID: "abc", Amount: 42})
+	_, _ = js.Publish(context.Background(), "typed.poison", []byte("{not json"))
+	_, _ = js.Publish(context.Background(), "typed.placed", raw)
+
+	n := startTestNode(t)
+	defer n.Stop()
+
+	consumer := &orderConsumer{
+		opts: TypedPullConsumerOptions[orderPlaced]{
+			PullConsumerOptions: PullConsumerOptions{
+				Connection: nc,
+				StreamName: "TYPED",
+				NatsConsumerConfig: jetstream.ConsumerConfig{
+					Durable: "typed",
+				},
+				AutoAck: true,
+			},
+		},
+	}
+	if _, err := n.Spawn("typed", gen.ProcessOptions{}, consumer); err != nil {
+		t.Fatalf("failed to spawn consumer: %s", err)
+	}
+
+	waitFor(t, func() bool { return consumer.count() == 1 })
+	msg := consumer.received[0]
+	if msg.Value.OrderID != "abc" || msg.Value.Amount != 42 || msg.Metadata.Sequence.Stream != 2 {
+		t.Fatalf("message was not decoded properly: %+v", msg)
+	}
+
+	cons, _ := js.Consumer(context.Background(), "TYPED", "typed")
+	waitFor(t, func() bool {
+		info, err := cons.Info(context.Background())
+		return err == nil && info.NumAckPending == 0 && info.NumRedelivered == 0
+	})
+}
+
+// countingCodec decodes JSON and counts the attempts, so that tests can tell
+// whether a poison message was redelivered
+func countingCodec(decoded *atomic.Int32) Codec[orderPlaced] {
+	codec := JSONCodec[orderPlaced]()
+	return CodecFunc[orderPlaced](func(data []byte, header nats.Header) (orderPlaced, error) {
+		decoded.Add(1)
+		return codec.Decode(data, header)
+	})
+}
+
+func TestTypedPullConsumerPoisonPolicies(t *testing.T) {
+	shutdown, nc := startNatsServer(t)
+	defer shutdown()
+
+	n := startTestNode(t)
+	defer n.Stop()
+
+	spawn := func(name string, policy PoisonPolicy, dlq *DeadLetterOptions) (jetstream.JetStream, *atomic.Int32) {
+		stream := strings.ToUpper(name)
+		js := createTestStream(t, nc, stream, name+".>")
+		decoded := &atomic.Int32{}
+		consumer := &orderConsumer{
+			opts: TypedPullConsumerOptions[orderPlaced]{
+				PullConsumerOptions: PullConsumerOptions{
+					Connection: nc,
+					StreamName: stream,
+					NatsConsumerConfig: jetstream.ConsumerConfig{
+						Durable:    name,
+						MaxDeliver: 5,
+					},
+					AutoAck:    true,
+					DeadLetter: dlq,
+				},
+				Codec:        countingCodec(decoded),
+				PoisonPolicy: policy,
+			},
+		}
+		if _, err := n.Spawn(name, gen.ProcessOptions{}, consumer); err != nil {
+			t.Fatalf("failed to spawn consumer: %s", err)
+		}
+		if dlq != nil && dlq.StreamName != "" {
+			waitFor(t, func() bool {
+				_, err := js.Stream(context.Background(), dlq.StreamName)
+				return err == nil
+			})
+		}
+		_, _ = js.Publish(context.Background(), name+".poison", []byte("{not json"))
+		return js, decoded
+	}
+
+	// naked poison messages are redelivered
+	_, decoded := spawn("poisonnak", PoisonNak, nil)
+	waitFor(t, func() bool { return decoded.Load() >= 2 })
+
+	// dead-lettered ones are republished with their metadata and never
+	// redelivered
+	js, decoded := spawn("poisondlq", PoisonDeadLetter, &DeadLetterOptions{
+		Subject:    "dlq.poisondlq",
+		StreamName: "POISONDLQ_DLQ",
+	})
+	dlq, _ := js.Stream(context.Background(), "POISONDLQ_DLQ")
+	var raw *jetstream.RawStreamMsg
+	waitFor(t, func() bool {
+		var err error
+		raw, err = dlq.GetMsg(context.Background(), 1)
+		return err == nil
+	})
+	if raw.Header.Get(HeaderDeadLetterSubject) != "poisondlq.poison" ||
+		raw.Header.Get(HeaderDeadLetterStream) != "POISONDLQ" ||
+		raw.Header.Get(HeaderDeadLetterConsumer) != "poisondlq" ||
+		raw.Header.Get(HeaderDeadLetterDeliveries) != "1" ||
+		!strings.HasPrefix(raw.Header.Get(HeaderDeadLetterError), "failed to decode message") ||
+		string(raw.Data) != "{not json" {
+		t.Fatalf("dead letter is missing poison metadata: %+v", raw.Header)
+	}
+	cons, _ := js.Consumer(context.Background(), "POISONDLQ", "poisondlq")
+	waitFor(t, func() bool {
+		info, err := cons.Info(context.Background())
+		return err == nil && info.NumAckPending == 0
+	})
+	if decoded.Load() != 1 {
+		t.Fatalf("dead-lettered poison message was redelivered")
+	}
+
+	// and naked when dead-lettering fails, here for want of a stream
+	_, decoded = spawn("poisonlost", PoisonDeadLetter, &DeadLetterOptions{Subject: "dlq.nowhere"})
+	waitFor(t, func() bool { return decoded.Load() >= 2 })
+}